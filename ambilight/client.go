@@ -1,9 +1,11 @@
 package ambilight
 
 import (
+	"bufio"
 	"fmt"
 	"log"
 	"net"
+	"strings"
 
 	"github.com/studentkittens/eulenfunk/util"
 )
@@ -12,18 +14,26 @@ import (
 // and can enable/disable the led playback, check the state
 // or quit the daemon remotely.
 type Client struct {
-	conn net.Conn
+	conn   net.Conn
+	reader *bufio.Reader
 }
 
 // NewClient creates a new Client from the cfg.Host and cfg.Port
 func NewClient(cfg *Config) (*Client, error) {
-	addr := fmt.Sprintf("%s:%d", cfg.AmbiHost, cfg.AmbiPort)
-	conn, err := net.Dial("tcp", addr)
+	conn, err := util.Dial(cfg.AmbiHost, cfg.AmbiPort)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{conn: conn}, nil
+	cl := &Client{conn: conn, reader: bufio.NewReader(conn)}
+	if cfg.AmbiToken != "" {
+		if err := cl.send("auth " + cfg.AmbiToken); err != nil {
+			util.Closer(conn)
+			return nil, err
+		}
+	}
+
+	return cl, nil
 }
 
 func (cl *Client) send(s string) error {
@@ -42,12 +52,36 @@ func (cl *Client) Enabled() (bool, error) {
 		return false, err
 	}
 
-	resp := make([]byte, 2)
-	if _, err := cl.conn.Read(resp); err != nil {
+	resp, err := cl.readLine()
+	if err != nil {
 		return false, err
 	}
 
-	return string(resp) == "1\n", nil
+	return resp == "1", nil
+}
+
+// readLine reads a reply; "ERR <message>" replies are returned as error.
+func (cl *Client) readLine() (string, error) {
+	resp, err := cl.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	resp = strings.TrimSpace(resp)
+	if strings.HasPrefix(resp, "ERR ") {
+		return "", fmt.Errorf("ambilightd: %s", strings.TrimPrefix(resp, "ERR "))
+	}
+
+	return resp, nil
+}
+
+// Health returns the health of the driver of ambilightd, e.g. "up" or "up(3)".
+func (cl *Client) Health() (string, error) {
+	if err := cl.send("health"); err != nil {
+		return "", err
+	}
+
+	return cl.readLine()
 }
 
 // Enable enables or disables the playback of ambilight.
@@ -72,10 +106,11 @@ func (cl *Client) Close() error {
 
 // WithClient is a convinience function to execute a code snippet with
 // an ambilight connection.
-func WithClient(host string, port int, fn func(client *Client) error) error {
+func WithClient(host string, port int, token string, fn func(client *Client) error) error {
 	client, err := NewClient(&Config{
-		AmbiHost: host,
-		AmbiPort: port,
+		AmbiHost:  host,
+		AmbiPort:  port,
+		AmbiToken: token,
 	})
 
 	if err != nil {
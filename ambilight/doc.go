@@ -19,8 +19,17 @@
 //  off     -- Turn off the payback.
 //  on      -- Turn the playback on.
 //  state   -- Print the state ("1\n" or "0\n" on the socket)
+//  health  -- Print the health of the driver ("up", "down" or "up(3)"
+//             after 3 restarts) as one line.
 //  quit    -- Quit ambilightd.
 //  close   -- Terminate the connection.
+//  auth <token>
+//          -- Authenticate with <token>.
+//
+// If Config.AmbiControlToken is set, on, off and quit need that token.
+// state and health need Config.AmbiReadToken (or the control token) if that is set.
+// Commands without the needed permission and a wrong token are answered
+// with "ERR <message>".
 //
 // (*) Fixed number given by the moodbar, okay for most songs today,
 //     not very suitable for e.g. Moonsorrow with their 30+ minute songs.
@@ -42,12 +42,23 @@ type Config struct {
 	// Lightd port  (usually 3333)
 	LightdPort int
 
+	// LightdToken is sent to lightd to authenticate (if not empty)
+	LightdToken string
+
 	// Host of the ambilight command server
 	AmbiHost string
 
 	// Port of the command server
 	AmbiPort int
 
+	// AmbiToken is sent by clients of the command server to authenticate
+	AmbiToken string
+
+	// AmbiControlToken and AmbiReadToken protect the command server.
+	// `state` only needs read access (see util.Tokens).
+	AmbiControlToken string
+	AmbiReadToken    string
+
 	// MusicDir is the root path of the mpd database
 	MusicDir string
 
@@ -59,6 +70,9 @@ type Config struct {
 
 	// Name of the RGB LED driver binary (`catlight` for my desktop)
 	BinaryName string
+
+	// Calibration is applied to every color sent to the driver (may be nil).
+	Calibration *lightd.Calibration
 }
 
 // server holds all runtime info for ambilightd.
@@ -73,6 +87,9 @@ type server struct {
 	stateCh chan bool
 	enabled bool
 
+	// driver is the catlight of moodbarRunner; nil until it started.
+	driver *util.Supervisor
+
 	mu sync.Mutex
 }
 
@@ -238,20 +255,18 @@ func createBlend(c1, c2 timedColor, N int) []timedColor {
 	return colors
 }
 
-func createDriverPipe(cfg *Config) (io.WriteCloser, error) {
-	cmd := exec.Command(cfg.BinaryName, "cat")
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		log.Printf("Cannot fork catlight: %v", err)
-		return nil, err
-	}
+// createDriverPipe starts `catlight cat` and restarts it when it crashes.
+// A restarted catlight gets the last color again.
+func createDriverPipe(cfg *Config) (*util.Supervisor, error) {
+	supervisor := util.NewSupervisor(cfg.BinaryName, "cat")
+	supervisor.RepeatLastWrite = true
 
-	if err := cmd.Start(); err != nil {
+	if err := supervisor.Start(); err != nil {
 		log.Printf("Failed to start `catlight cat`: %v", err)
 		return nil, err
 	}
 
-	return stdin, nil
+	return supervisor, nil
 }
 
 // moodbarRunner sets the current color and blends to it
@@ -266,6 +281,10 @@ func moodbarRunner(server *server, colors <-chan timedColor) {
 
 	defer util.Closer(stdin)
 
+	server.mu.Lock()
+	server.driver = stdin
+	server.mu.Unlock()
+
 	// First color is always black.
 	var lastColor timedColor
 
@@ -274,7 +293,19 @@ func moodbarRunner(server *server, colors <-chan timedColor) {
 
 	var enabled = true
 
+	// The color last written before calibration; it is written again when
+	// the night limit changes, even if nothing plays.
+	var written *timedColor
+	nightChange := cfg.Calibration.NextChange(time.Now())
+
 	for {
+		if now := time.Now(); !nightChange.IsZero() && !now.Before(nightChange) {
+			nightChange = cfg.Calibration.NextChange(now)
+			if written != nil && len(blend) == 0 {
+				blend = []timedColor{{written.R, written.G, written.B, 0}}
+			}
+		}
+
 		select {
 		case newState := <-server.stateCh:
 			enabled = newState
@@ -302,11 +333,13 @@ func moodbarRunner(server *server, colors <-chan timedColor) {
 				color := blend[0]
 				blend = blend[1:]
 
-				colorValue := fmt.Sprintf("%d %d %d\n", color.R, color.G, color.B)
+				colorValue := cfg.Calibration.Line(color.R, color.G, color.B)
 				if _, err := stdin.Write([]byte(colorValue)); err != nil {
 					log.Printf("Failed to write color to driver: %v", err)
 				}
 
+				written = &color
+
 				time.Sleep(color.Duration)
 			} else {
 				// Nothing to blend over; wait a bit:
@@ -379,8 +412,9 @@ func moodbarAdjuster(srv *server, eventCh <-chan mpdEvent, colorsCh chan<- timed
 	initialSend := true
 
 	lightdConfig := &lightd.Config{
-		Host: srv.Config.LightdHost,
-		Port: srv.Config.LightdPort,
+		Host:  srv.Config.LightdHost,
+		Port:  srv.Config.LightdPort,
+		Token: srv.Config.LightdToken,
 	}
 
 	locker, err := lightd.NewLocker(lightdConfig)
@@ -571,12 +605,49 @@ func Watcher(server *server) error {
 	return nil
 }
 
+// ambiPermissions is the permission needed by each command.
+var ambiPermissions = map[string]util.Permission{
+	"on":     util.PermControl,
+	"off":    util.PermControl,
+	"quit":   util.PermControl,
+	"state":  util.PermRead,
+	"health": util.PermRead,
+	"close":  util.PermNone,
+}
+
+// replyErr logs `err` and sends it to the client as "ERR <message>".
+func replyErr(conn net.Conn, err error) {
+	log.Printf("%v", err)
+	if _, err := fmt.Fprintf(conn, "ERR %v\n", err); err != nil {
+		log.Printf("Failed to write back error response: %v", err)
+	}
+}
+
 func handleConn(server *server, conn net.Conn) {
 	defer util.Closer(conn)
 
+	access := util.NewAccess(util.Tokens{
+		Control: server.Config.AmbiControlToken,
+		Read:    server.Config.AmbiReadToken,
+	})
+
 	scn := bufio.NewScanner(conn)
 	for scn.Scan() {
-		switch cmd := scn.Text(); cmd {
+		cmd := scn.Text()
+		if strings.HasPrefix(cmd, "auth ") {
+			if err := access.Auth(strings.TrimPrefix(cmd, "auth ")); err != nil {
+				replyErr(conn, fmt.Errorf("Authentication failed: %v", err))
+			}
+
+			continue
+		}
+
+		if err := access.Check(cmd, ambiPermissions[cmd]); err != nil {
+			replyErr(conn, err)
+			continue
+		}
+
+		switch cmd {
 		case "off":
 			log.Printf("Disabling ambilight...")
 			server.stateCh <- false
@@ -603,6 +674,18 @@ func handleConn(server *server, conn net.Conn) {
 			if _, err := conn.Write(resp); err != nil {
 				log.Printf("Failed to write back state response: %v", err)
 			}
+		case "health":
+			health := "down"
+
+			server.mu.Lock()
+			if server.driver != nil {
+				health = server.driver.Health().String()
+			}
+			server.mu.Unlock()
+
+			if _, err := conn.Write([]byte(health + "\n")); err != nil {
+				log.Printf("Failed to write back health response: %v", err)
+			}
 		case "quit":
 			log.Printf("Quitting ambilightd...")
 			server.Cancel()
@@ -618,13 +701,12 @@ func handleConn(server *server, conn net.Conn) {
 }
 
 func createNetworkListener(server *server) error {
-	addr := fmt.Sprintf("%s:%d", server.Config.AmbiHost, server.Config.AmbiPort)
-	lsn, err := net.Listen("tcp", addr)
+	lsn, err := util.Listen(server.Config.AmbiHost, server.Config.AmbiPort)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Listening on %v", addr)
+	log.Printf("Listening on %v", lsn.Addr())
 
 	stdin, err := createDriverPipe(server.Config)
 	if err != nil {
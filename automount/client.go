@@ -14,12 +14,18 @@ type Client struct {
 
 // NewClient returns a new automountd convinience client.
 func NewClient(cfg *Config) (*Client, error) {
-	addr := fmt.Sprintf("%s:%d", cfg.AutomountHost, cfg.AutomountPort)
-	conn, err := net.Dial("tcp", addr)
+	conn, err := util.Dial(cfg.AutomountHost, cfg.AutomountPort)
 	if err != nil {
 		return nil, err
 	}
 
+	if cfg.AutomountToken != "" {
+		if _, err := conn.Write([]byte("auth " + cfg.AutomountToken + "\n")); err != nil {
+			util.Closer(conn)
+			return nil, err
+		}
+	}
+
 	return &Client{conn}, nil
 }
 
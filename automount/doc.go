@@ -7,4 +7,9 @@
 // unmount <device>         # Unmount the device again.
 // close                    # Close the connection early.
 // quit                     # Quit automountd.
+// auth <token>             # Authenticate with <token>.
+//
+// If Config.AutomountControlToken is set, everything but close and auth
+// is refused until the connection sent that token. Refused commands and a
+// wrong token are answered with "ERR <message>"; nothing else is answered.
 package automount
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,6 +13,7 @@ import (
 	"time"
 
 	"github.com/fhs/gompd/mpd"
+	"github.com/studentkittens/eulenfunk/display"
 	"github.com/studentkittens/eulenfunk/util"
 	"golang.org/x/net/context"
 )
@@ -30,6 +30,21 @@ type Config struct {
 	MPDHost       string
 	MPDPort       int
 	MusicDir      string
+
+	// AutomountToken is sent by clients to authenticate, if not empty.
+	AutomountToken string
+
+	// AutomountControlToken protects automountd (see util.Tokens).
+	// All of its commands need control access.
+	AutomountControlToken string
+
+	// DisplayHost and DisplayPort of displayd for notifications.
+	// No notifications are shown if DisplayHost is empty.
+	DisplayHost string
+	DisplayPort int
+
+	// DisplayToken is used to authenticate with displayd.
+	DisplayToken string
 }
 
 type server struct {
@@ -246,21 +261,73 @@ func (srv *server) unmount(device, label string) error {
 	return nil
 }
 
-func (srv *server) handleLine(line string) bool {
-	log.Printf("Received: %v", line)
+// notify shows a short popup on displayd in the background.
+func (srv *server) notify(lines ...string) {
+	if srv.Config.DisplayHost == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(srv.Context, 10*time.Second)
+		defer cancel()
+
+		cfg := &display.Config{
+			Host:  srv.Config.DisplayHost,
+			Port:  srv.Config.DisplayPort,
+			Token: srv.Config.DisplayToken,
+		}
+
+		if err := display.Notify(cfg, ctx, "popup-automount", 4*time.Second, lines...); err != nil {
+			log.Printf("Failed to show notification: %v", err)
+		}
+	}()
+}
+
+// replyErr logs `err` and sends it to the client as "ERR <message>".
+func replyErr(conn io.Writer, err error) {
+	log.Printf("%v", err)
+	if _, err := fmt.Fprintf(conn, "ERR %v\n", err); err != nil {
+		log.Printf("Failed to send error response: %v", err)
+	}
+}
+
+func (srv *server) handleLine(conn io.Writer, line string, access *util.Access) bool {
 	split := strings.Split(line, " ")
 
+	if split[0] == "auth" && len(split) == 2 {
+		if err := access.Auth(split[1]); err != nil {
+			replyErr(conn, fmt.Errorf("Authentication failed: %v", err))
+		}
+
+		return true
+	}
+
+	log.Printf("Received: %v", line)
+
+	// Only close may be used by anyone:
+	if split[0] != "close" {
+		if err := access.Check(split[0], util.PermControl); err != nil {
+			replyErr(conn, err)
+			return true
+		}
+	}
+
 	switch split[0] {
 	case "mount":
 		if len(split) >= 3 {
 			if err := srv.mount(split[1], split[2]); err != nil {
 				log.Printf("Failed to mount: %v", err)
+				srv.notify("USB mount failed:", split[2])
+			} else {
+				srv.notify("USB stick mounted:", split[2])
 			}
 		}
 	case "unmount":
 		if len(split) >= 3 {
 			if err := srv.unmount(split[1], split[2]); err != nil {
 				log.Printf("Failed to unmount: %v", err)
+			} else {
+				srv.notify("USB stick removed:", split[2])
 			}
 		}
 	case "close":
@@ -273,12 +340,14 @@ func (srv *server) handleLine(line string) bool {
 	return true
 }
 
-func (srv *server) handleRequests(conn io.ReadCloser) {
+func (srv *server) handleRequests(conn io.ReadWriteCloser) {
 	defer util.Closer(conn)
 
+	access := util.NewAccess(util.Tokens{Control: srv.Config.AutomountControlToken})
+
 	scanner := bufio.NewScanner(conn)
 	for scanner.Scan() {
-		if !srv.handleLine(strings.TrimSpace(scanner.Text())) {
+		if !srv.handleLine(conn, strings.TrimSpace(scanner.Text()), access) {
 			break
 		}
 	}
@@ -299,8 +368,7 @@ func cancelled(ctx context.Context) bool {
 
 // Run creates a new automountd on the specified host and port.
 func Run(cfg *Config, ctx context.Context) error {
-	addr := fmt.Sprintf("%s:%d", cfg.AutomountHost, cfg.AutomountPort)
-	lsn, err := net.Listen("tcp", addr)
+	lsn, err := util.Listen(cfg.AutomountHost, cfg.AutomountPort)
 	if err != nil {
 		log.Printf("Error listening: %v", err.Error())
 		return err
@@ -315,7 +383,7 @@ func Run(cfg *Config, ctx context.Context) error {
 	}
 
 	defer util.Closer(lsn)
-	log.Println("Listening on " + addr)
+	log.Printf("Listening on %s", lsn.Addr())
 
 	// Manually trigger a udevadm run after a few seconds:
 	go func() {
@@ -326,23 +394,16 @@ func Run(cfg *Config, ctx context.Context) error {
 	}()
 
 	for !cancelled(ctx) {
-		if tcpLsn, ok := lsn.(*net.TCPListener); ok {
-			if err := tcpLsn.SetDeadline(time.Now().Add(2 * time.Second)); err != nil {
-				log.Printf("Setting deadline failed: %v", err)
-				return err
-			}
-		}
-
-		conn, err := lsn.Accept()
-		if err, ok := err.(*net.OpError); ok && err.Timeout() {
-			continue
-		}
-
+		conn, err := util.Accept(lsn, 2*time.Second)
 		if err != nil {
 			log.Printf("Error accepting: %v", err.Error())
 			return err
 		}
 
+		if conn == nil {
+			continue
+		}
+
 		go srv.handleRequests(conn)
 	}
 
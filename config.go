@@ -0,0 +1,421 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/studentkittens/eulenfunk/display"
+	"github.com/studentkittens/eulenfunk/lightd"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigPath is where the config file is read from by default.
+// It is fine if it does not exist.
+const DefaultConfigPath = "/etc/eulenfunk.yml"
+
+// configFile is the parsed config file. Its keys are the names of flags:
+// top level keys apply to every command with such a flag, keys in a section
+// named like a command (or subcommand) only to that command. E.g.:
+//
+//	width: 20
+//	display-host: unix:///run/eulenfunk/displayd.sock
+//	display:
+//	  server:
+//	    driver: radio-lcd
+//	lightd:
+//	  driver: radio-led
+//
+// Flags given on the command line or by environment variables win.
+type configFile map[string]interface{}
+
+func loadConfigFile(path string) (configFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := configFile{}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return file, nil
+}
+
+// section returns the section `name` of `file`, or nil if there is none.
+func (file configFile) section(name string) configFile {
+	// yaml decodes nested maps with the type of the outer one:
+	switch sub := file[name].(type) {
+	case configFile:
+		return sub
+	case map[string]interface{}:
+		return configFile(sub)
+	}
+
+	return nil
+}
+
+func flagName(flag cli.Flag) string {
+	return strings.Split(flag.GetName(), ",")[0]
+}
+
+// configString converts a scalar value of the config file to a string.
+func configString(name string, value interface{}) (string, error) {
+	switch value.(type) {
+	case string, int, float64, bool:
+		return fmt.Sprint(value), nil
+	}
+
+	return "", fmt.Errorf("`%s` needs a single value", name)
+}
+
+// withDefault returns `flag` with `value` from the config file as default.
+func withDefault(flag cli.Flag, value interface{}) (cli.Flag, error) {
+	name := flagName(flag)
+
+	if slice, ok := flag.(cli.StringSliceFlag); ok {
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+
+		slice.Value = &cli.StringSlice{}
+		for _, elem := range values {
+			str, err := configString(name, elem)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := slice.Value.Set(str); err != nil {
+				return nil, err
+			}
+		}
+
+		return slice, nil
+	}
+
+	str, err := configString(name, value)
+	if err != nil {
+		return nil, err
+	}
+
+	var parseErr error
+	switch f := flag.(type) {
+	case cli.StringFlag:
+		f.Value = str
+		return f, nil
+	case cli.IntFlag:
+		_, parseErr = fmt.Sscanf(str, "%d", &f.Value)
+		return f, badValue(name, str, parseErr)
+	case cli.Float64Flag:
+		_, parseErr = fmt.Sscanf(str, "%g", &f.Value)
+		return f, badValue(name, str, parseErr)
+	case cli.DurationFlag:
+		f.Value, parseErr = time.ParseDuration(str)
+		return f, badValue(name, str, parseErr)
+	case cli.BoolFlag:
+		switch str {
+		case "true":
+			return cli.BoolTFlag{Name: f.Name, Usage: f.Usage, EnvVar: f.EnvVar}, nil
+		case "false":
+			return f, nil
+		}
+
+		return nil, fmt.Errorf("Bad value `%s` for `%s` (true or false expected)", str, name)
+	}
+
+	return nil, fmt.Errorf("`%s` can not be set in the config file", name)
+}
+
+func badValue(name, value string, err error) error {
+	if err != nil {
+		return fmt.Errorf("Bad value `%s` for `%s`: %v", value, name, err)
+	}
+
+	return nil
+}
+
+// applyFlags sets the defaults of `flags` from `sections`; the most specific
+// section (the last one) wins. It returns the names of the flags.
+func applyFlags(flags []cli.Flag, sections []configFile) (map[string]bool, error) {
+	names := make(map[string]bool)
+
+	for idx, flag := range flags {
+		name := flagName(flag)
+		names[name] = true
+
+		for sdx := len(sections) - 1; sdx >= 0; sdx-- {
+			value, ok := sections[sdx][name]
+			if !ok {
+				continue
+			}
+
+			newFlag, err := withDefault(flag, value)
+			if err != nil {
+				return nil, err
+			}
+
+			flags[idx] = newFlag
+			break
+		}
+	}
+
+	return names, nil
+}
+
+// checkKeys returns an error if `section` has keys that are neither in
+// `names` nor sections of one of `cmds`.
+func checkKeys(section configFile, names map[string]bool, cmds []cli.Command) error {
+	for _, cmd := range cmds {
+		names[cmd.Name] = true
+	}
+
+	unknown := []string{}
+	for key := range section {
+		if !names[key] {
+			unknown = append(unknown, key)
+		}
+	}
+
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+	return fmt.Errorf("Unknown keys: %s", strings.Join(unknown, ", "))
+}
+
+// applyCommands applies `sections` to `cmds` and their subcommands.
+// It returns the names of all flags of them.
+func applyCommands(cmds []cli.Command, sections []configFile) (map[string]bool, error) {
+	names := make(map[string]bool)
+
+	for idx := range cmds {
+		cmd := &cmds[idx]
+
+		section := sections[len(sections)-1].section(cmd.Name)
+		cmdSections := append(sections[:len(sections):len(sections)], section)
+
+		flagNames, err := applyFlags(cmd.Flags, cmdSections)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", cmd.Name, err)
+		}
+
+		subNames, err := applyCommands(cmd.Subcommands, cmdSections)
+		if err != nil {
+			return nil, fmt.Errorf("%s %v", cmd.Name, err)
+		}
+
+		for name := range subNames {
+			flagNames[name] = true
+		}
+
+		if err := checkKeys(section, flagNames, cmd.Subcommands); err != nil {
+			return nil, fmt.Errorf("%s: %v", cmd.Name, err)
+		}
+
+		for name := range flagNames {
+			names[name] = true
+		}
+	}
+
+	return names, nil
+}
+
+// applyConfigFile loads the config file given by --config and uses its
+// values as defaults of all commands of `app`.
+func applyConfigFile(app *cli.App, ctx *cli.Context) error {
+	path := ctx.GlobalString("config")
+
+	file, err := loadConfigFile(path)
+	if os.IsNotExist(err) && !ctx.GlobalIsSet("config") {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Global flags are already parsed at this point:
+	names := make(map[string]bool)
+	for _, flag := range app.Flags {
+		name := flagName(flag)
+		names[name] = true
+
+		if value, ok := file[name]; ok && !ctx.GlobalIsSet(name) {
+			str, err := configString(name, value)
+			if err != nil {
+				return err
+			}
+
+			if err := ctx.GlobalSet(name, str); err != nil {
+				return badValue(name, str, err)
+			}
+		}
+	}
+
+	cmdNames, err := applyCommands(app.Commands, []configFile{file})
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	for name := range cmdNames {
+		names[name] = true
+	}
+
+	if err := checkKeys(file, names, app.Commands); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	return nil
+}
+
+// findFlag returns the flag `name` of the command at `path` (e.g. "display",
+// "server"), or nil if there is none. Its default includes the config file.
+func findFlag(app *cli.App, name string, path ...string) cli.Flag {
+	cmds, flags := app.Commands, app.Flags
+
+	for _, cmdName := range path {
+		found := false
+		for _, cmd := range cmds {
+			if cmd.Name == cmdName {
+				cmds, flags, found = cmd.Subcommands, cmd.Flags, true
+				break
+			}
+		}
+
+		if !found {
+			return nil
+		}
+	}
+
+	for _, flag := range flags {
+		if flagName(flag) == name {
+			return flag
+		}
+	}
+
+	return nil
+}
+
+// stringDefault returns the default of a string flag; see findFlag.
+func stringDefault(app *cli.App, name string, path ...string) string {
+	if flag, ok := findFlag(app, name, path...).(cli.StringFlag); ok {
+		return flag.Value
+	}
+
+	return ""
+}
+
+// floatDefault returns the default of a float flag; see findFlag.
+func floatDefault(app *cli.App, name string, path ...string) float64 {
+	if flag, ok := findFlag(app, name, path...).(cli.Float64Flag); ok {
+		return flag.Value
+	}
+
+	return 0
+}
+
+// sliceDefault returns the default of a string slice flag; see findFlag.
+func sliceDefault(app *cli.App, name string, path ...string) []string {
+	if flag, ok := findFlag(app, name, path...).(cli.StringSliceFlag); ok && flag.Value != nil {
+		return flag.Value.Value()
+	}
+
+	return nil
+}
+
+// checkDriver returns an error if `driver` is not installed. This is only a
+// warning: the config may well be checked on another machine than the radio.
+func checkDriver(driver string) error {
+	if driver == "" || driver == display.VirtualDriverName {
+		return nil
+	}
+
+	if _, err := exec.LookPath(driver); err != nil {
+		return fmt.Errorf("Driver `%s` is not installed", driver)
+	}
+
+	return nil
+}
+
+// checkConfigValues validates values that parse fine, but make no sense.
+// It looks at the flag defaults of `app` after the config file was applied,
+// so top level keys are checked too. It returns one problem per entry and
+// warnings about things that are only missing on this machine.
+func checkConfigValues(app *cli.App) (problems, warnings []string) {
+	report := func(prefix string, err error) {
+		if err != nil {
+			problems = append(problems, prefix+": "+err.Error())
+		}
+	}
+
+	warn := func(prefix string, err error) {
+		if err != nil {
+			warnings = append(warnings, prefix+": "+err.Error())
+		}
+	}
+
+	server := []string{"display", "server"}
+	_, noEncoding := findFlag(app, "no-encoding", server...).(cli.BoolTFlag)
+	cfg := &display.Config{
+		Encoding:    stringDefault(app, "encoding", server...),
+		NoEncoding:  noEncoding,
+		Night:       stringDefault(app, "night", server...),
+		Screensaver: stringDefault(app, "screensaver", server...),
+	}
+
+	report("display server", cfg.Check())
+
+	for _, spec := range sliceDefault(app, "output", server...) {
+		_, err := display.ParseOutput(spec)
+		report("display server", err)
+	}
+
+	warn("display server", checkDriver(stringDefault(app, "driver", server...)))
+
+	for _, cmd := range []string{"lightd", "ambilight"} {
+		_, err := lightd.ParseCalibration(
+			stringDefault(app, "led-gamma", cmd),
+			stringDefault(app, "led-white-balance", cmd),
+			floatDefault(app, "led-max-brightness", cmd),
+			stringDefault(app, "led-night", cmd),
+			floatDefault(app, "led-night-brightness", cmd),
+		)
+
+		report(cmd, err)
+		warn(cmd, checkDriver(stringDefault(app, "driver", cmd)))
+	}
+
+	return problems, warnings
+}
+
+func handleConfigCheck(app *cli.App) func(ctx *cli.Context) error {
+	return func(ctx *cli.Context) error {
+		path := ctx.GlobalString("config")
+
+		// applyConfigFile already ran (and failed if the file is broken):
+		problems, warnings := checkConfigValues(app)
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", path, problem)
+		}
+
+		// Warnings do not fail the check:
+		for _, warning := range warnings {
+			fmt.Printf("%s: warning: %s\n", path, warning)
+		}
+
+		if len(problems) > 0 {
+			return cli.NewExitError("", 1)
+		}
+
+		fmt.Printf("%s: OK\n", path)
+		return nil
+	}
+}
@@ -0,0 +1,162 @@
+package display
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/studentkittens/eulenfunk/util"
+)
+
+const (
+	// MaxLevel is the highest level of the backlight and the contrast.
+	MaxLevel = 100
+
+	// DefaultBacklight is the backlight level displayd starts with.
+	DefaultBacklight = MaxLevel
+
+	// DefaultContrast is the contrast level displayd starts with.
+	DefaultContrast = 50
+)
+
+// lighting is the state of the backlight and the contrast.
+type lighting struct {
+	// Backlight and Contrast are the levels requested by clients.
+	Backlight int
+	Contrast  int
+
+	// LastInput is the time when the user touched the radio last.
+	LastInput time.Time
+
+	// Night dims the backlight at night; nil if it is not used.
+	Night *util.NightSchedule
+
+	// The levels last sent to the driver; -1 if none was sent yet.
+	sentBacklight int
+	sentContrast  int
+}
+
+func (srv *server) initLighting() error {
+	night, err := util.ParseNightSchedule(srv.Config.Night)
+	if err != nil {
+		return err
+	}
+
+	srv.Light = lighting{
+		Backlight:     DefaultBacklight,
+		Contrast:      DefaultContrast,
+		LastInput:     time.Now(),
+		Night:         night,
+		sentBacklight: -1,
+		sentContrast:  -1,
+	}
+
+	if srv.Config.Backlight > 0 {
+		srv.Light.Backlight = srv.Config.Backlight
+	}
+
+	if srv.Config.Contrast > 0 {
+		srv.Light.Contrast = srv.Config.Contrast
+	}
+
+	return nil
+}
+
+// backlight returns the backlight level at `now` after dimming and when
+// it might change next (zero if it will not change by itself).
+func (srv *server) backlight(now time.Time) (int, time.Time) {
+	level, next := srv.Light.Backlight, time.Time{}
+
+	if dimAfter := srv.Config.DimAfter; dimAfter > 0 {
+		if dimAt := srv.Light.LastInput.Add(dimAfter); now.Before(dimAt) {
+			next = dimAt
+		} else if level > srv.Config.DimLevel {
+			level = srv.Config.DimLevel
+		}
+	}
+
+	if srv.Saver.Window != nil && srv.Saver.Mode == ScreensaverBlank {
+		level = 0
+	}
+
+	if night := srv.Light.Night; night != nil {
+		if night.Contains(now) && level > srv.Config.NightLevel {
+			level = srv.Config.NightLevel
+		}
+
+		next = earliest(next, night.Next(now))
+	}
+
+	return level, next
+}
+
+// sendLevel sends a control line like "!backlight 100" to the driver.
+func (srv *server) sendLevel(name string, level int) {
+	if _, err := fmt.Fprintf(srv.DriverPipe, "!%s %d\n", name, level); err != nil {
+		log.Printf("Failed to send %s to driver: %v", name, err)
+	}
+}
+
+// updateLighting sends changed levels to the driver. It returns when
+// it needs to be called next (zero if not needed).
+func (srv *server) updateLighting(now time.Time) time.Time {
+	level, next := srv.backlight(now)
+
+	if level != srv.Light.sentBacklight {
+		log.Printf("Setting backlight to %d", level)
+		srv.sendLevel("backlight", level)
+		srv.Light.sentBacklight = level
+	}
+
+	if srv.Light.Contrast != srv.Light.sentContrast {
+		srv.sendLevel("contrast", srv.Light.Contrast)
+		srv.Light.sentContrast = srv.Light.Contrast
+	}
+
+	return next
+}
+
+func checkLevel(name string, level int) error {
+	if level < 0 || level > MaxLevel {
+		return fmt.Errorf("Bad %s level %d (0-%d)", name, level, MaxLevel)
+	}
+
+	return nil
+}
+
+// SetBacklight sets the backlight level (0-MaxLevel) when not dimmed.
+func (srv *server) SetBacklight(level int) error {
+	srv.Lock()
+	defer srv.Unlock()
+
+	if err := checkLevel("backlight", level); err != nil {
+		return err
+	}
+
+	srv.Light.Backlight = level
+	srv.touch()
+	return nil
+}
+
+// SetContrast sets the contrast level (0-MaxLevel).
+func (srv *server) SetContrast(level int) error {
+	srv.Lock()
+	defer srv.Unlock()
+
+	if err := checkLevel("contrast", level); err != nil {
+		return err
+	}
+
+	srv.Light.Contrast = level
+	srv.touch()
+	return nil
+}
+
+// Input tells displayd that the user did something, which ends dimming.
+func (srv *server) Input() {
+	srv.Lock()
+	defer srv.Unlock()
+
+	srv.Light.LastInput = time.Now()
+	srv.touch()
+}
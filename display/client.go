@@ -3,14 +3,19 @@ package display
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/studentkittens/eulenfunk/util"
+
 	"golang.org/x/net/context"
 )
 
@@ -18,17 +23,41 @@ import (
 // It's not an abstraction over the text protocol, but an easy access to it.
 // It's methods will block if the displayd server does not exist yet or
 // crashed. It then attempts a reconnect in the background.
+//
+// In acknowledged mode (see Config.Acknowledged) every command waits for the
+// reply of displayd and rejected commands are returned as *ProtocolError.
+// With Config.Framed the framed protocol is used instead of the text protocol.
 type LineWriter struct {
-	host string
-	port int
+	sync.Mutex
+
+	host   string
+	port   int
+	ack    bool
+	framed bool
+	lastID int
+
+	// outputName is the output selected on every (re-)connect.
+	outputName string
+
+	// token is sent with `auth` on every (re-)connect, if not empty.
+	token string
 
 	conn   net.Conn
+	reader *bufio.Reader
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
 // Write sends arbitrary bytes to displayd. You should use Printf() instead.
+// It only makes sense with the text protocol.
 func (lw *LineWriter) Write(p []byte) (int, error) {
+	lw.Lock()
+	defer lw.Unlock()
+
+	return lw.write(p)
+}
+
+func (lw *LineWriter) write(p []byte) (int, error) {
 	if !bytes.HasSuffix(p, []byte("\n")) {
 		p = append(p, '\n')
 	}
@@ -53,6 +82,9 @@ func (lw *LineWriter) Write(p []byte) (int, error) {
 // Read reads a response from the display server.
 // This is only needed by the debugging dumping program.
 func (lw *LineWriter) Read(p []byte) (int, error) {
+	lw.Lock()
+	defer lw.Unlock()
+
 	for {
 		select {
 		case <-lw.ctx.Done():
@@ -60,7 +92,7 @@ func (lw *LineWriter) Read(p []byte) (int, error) {
 		default:
 		}
 
-		n, err := lw.conn.Read(p)
+		n, err := lw.reader.Read(p)
 
 		if err != nil {
 			lw.retryUntilSuccesfull()
@@ -72,95 +104,528 @@ func (lw *LineWriter) Read(p []byte) (int, error) {
 }
 
 // Printf formats and sends a message to displayd in a fmt.Printf like fashion.
+// In acknowledged mode it waits for the reply, so commands that are not
+// answered with OK or ERR (like render or quit) need Write instead.
+// It can not be used with the framed protocol.
 func (lw *LineWriter) Printf(format string, args ...interface{}) (int, error) {
-	return lw.Write([]byte(fmt.Sprintf(format, args...)))
+	lw.Lock()
+	defer lw.Unlock()
+
+	if lw.framed {
+		return 0, fmt.Errorf("Printf does not work with the framed protocol")
+	}
+
+	n, err := lw.write([]byte(fmt.Sprintf(format, args...)))
+	if err != nil || !lw.ack || cancelled(lw.ctx) {
+		return n, err
+	}
+
+	return n, lw.readReply()
+}
+
+func parseReply(reply string) error {
+	reply = strings.TrimRight(reply, "\r\n")
+	if reply == "OK" {
+		return nil
+	}
+
+	code, msg := 0, ""
+	if split := strings.SplitN(reply, " ", 3); len(split) >= 2 && split[0] == "ERR" {
+		var err error
+		if code, err = strconv.Atoi(split[1]); err == nil {
+			if len(split) > 2 {
+				msg = split[2]
+			}
+
+			return &ProtocolError{Code: code, Message: msg}
+		}
+	}
+
+	return fmt.Errorf("Bad reply from displayd: `%s`", reply)
+}
+
+func (lw *LineWriter) readReply() error {
+	reply, err := lw.reader.ReadString('\n')
+	if err != nil {
+		// The command might have been lost; make sure the next one works.
+		lw.retryUntilSuccesfull()
+		return err
+	}
+
+	return parseReply(reply)
+}
+
+// command sends a single command and waits for the reply if needed.
+func (lw *LineWriter) command(cmd string, args ...interface{}) error {
+	lw.Lock()
+	defer lw.Unlock()
+
+	if lw.framed {
+		_, err := lw.request(cmd, args)
+		return err
+	}
+
+	if _, err := lw.write([]byte(commandLine(cmd, args))); err != nil {
+		return err
+	}
+
+	if !lw.ack || cancelled(lw.ctx) {
+		return nil
+	}
+
+	return lw.readReply()
+}
+
+// output sends `cmd` and returns its output, e.g. for render and list.
+func (lw *LineWriter) output(cmd string) ([]byte, error) {
+	lw.Lock()
+	defer lw.Unlock()
+
+	if lw.framed {
+		resp, err := lw.request(cmd, nil)
+		if err != nil || resp == nil {
+			return nil, err
+		}
+
+		return []byte(resp.Data), nil
+	}
+
+	if _, err := lw.write([]byte(cmd)); err != nil {
+		return nil, err
+	}
+
+	data, err := readFramed(lw.reader)
+	if err != nil {
+		log.Printf("Reading output failed: %v", err)
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// request sends a single request in the framed protocol and waits for the
+// response. It returns nil if the LineWriter was closed in between.
+func (lw *LineWriter) request(cmd string, args []interface{}) (*frameResponse, error) {
+	rawArgs, err := frameArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	lw.lastID++
+	data, err := json.Marshal(frameRequest{ID: lw.lastID, Cmd: cmd, Args: rawArgs})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if cancelled(lw.ctx) {
+			return nil, nil
+		}
+
+		if err := writeFramed(lw.conn, data); err != nil {
+			lw.retryUntilSuccesfull()
+			continue
+		}
+
+		break
+	}
+
+	respData, err := readFramed(lw.reader)
+	if err != nil {
+		// The request might have been lost; make sure the next one works.
+		lw.retryUntilSuccesfull()
+		return nil, err
+	}
+
+	return parseFrameResponse(respData, lw.lastID)
 }
 
 // Line writes a line in `window` at lineno `pos` consisting of `text`
 func (lw *LineWriter) Line(window string, pos int, text string) error {
-	_, err := lw.Printf("line %s %d %s", window, pos, text)
-	return err
+	return lw.command("line", window, pos, text)
 }
 
 // ScrollDelay sets the delay between a scroll increment of the line in the
 // window `window` at position `pos` to `delay`.
 func (lw *LineWriter) ScrollDelay(window string, pos int, delay time.Duration) error {
-	_, err := lw.Printf("scroll %s %d %s", window, pos, delay.String())
-	return err
+	return lw.command("scroll", window, pos, delay)
+}
+
+// Attr sets attributes of the line at `pos` in `window`; see AttrAlign,
+// AttrScroll, AttrPause and AttrFill. Layout is done by displayd then:
+//
+//	lw.Attr("clock", 1, display.AttrAlign(display.AlignCenter))
+func (lw *LineWriter) Attr(window string, pos int, attrs ...string) error {
+	return lw.command("attr", window, pos, strings.Join(attrs, " "))
+}
+
+// AttrAlign returns the attribute for aligning text shorter than the line.
+// `how` is one of AlignLeft, AlignCenter or AlignRight.
+func AttrAlign(how string) string {
+	return "align=" + how
+}
+
+// AttrScroll returns the attribute for scrolling text longer than the line.
+// `mode` is one of ScrollWrap, ScrollBounce or ScrollOnce.
+func AttrScroll(mode string) string {
+	return "scroll=" + mode
+}
+
+// AttrPause returns the attribute for pausing the scrolling at the start.
+func AttrPause(pause time.Duration) string {
+	return "pause=" + pause.String()
+}
+
+// AttrFill returns the attribute for padding aligned text with `fill`.
+// A zero `fill` pads with blanks.
+func AttrFill(fill rune) string {
+	if fill == 0 {
+		return "fill="
+	}
+
+	return "fill=" + string(fill)
+}
+
+// Clock makes displayd show the current time in the line at `pos` of
+// `window`, formatted by the Go time `layout` (DefaultClockLayout if empty).
+func (lw *LineWriter) Clock(window string, pos int, layout string) error {
+	return lw.command("widget", window, pos, "clock", layout)
+}
+
+// Timer makes displayd show the time of `span` in the line at `pos` of
+// `window`. `format` may contain %e (elapsed), %r (remaining), %t (total)
+// and %% (a literal %); DefaultTimerFormat is used if it is empty.
+func (lw *LineWriter) Timer(window string, pos int, span TimeSpan, format string) error {
+	return lw.command("widget", window, pos, "timer", span, format)
+}
+
+// Progress makes displayd draw a bar of `bar` characters in the line at
+// `pos` of `window` that fills the line as `span` elapses.
+// A zero `bar` draws a horizontal line.
+func (lw *LineWriter) Progress(window string, pos int, span TimeSpan, bar rune) error {
+	if bar == 0 {
+		return lw.command("widget", window, pos, "progress", span)
+	}
+
+	return lw.command("widget", window, pos, "progress", span, string(bar))
+}
+
+// NoWidget stops displayd from generating the line at `pos` of `window`.
+// Writing the line with Line does the same.
+func (lw *LineWriter) NoWidget(window string, pos int) error {
+	return lw.command("widget", window, pos, "none")
 }
 
 // Switch makes `window` the active window.
 func (lw *LineWriter) Switch(window string) error {
-	_, err := lw.Printf("switch %s", window)
-	return err
+	return lw.command("switch", window)
 }
 
 // Move moves the window `window` down by `plus` lines.
 // `plus` may be negative to go up again.
 // Think of it as vertical scrolling.
 func (lw *LineWriter) Move(window string, plus int) error {
-	_, err := lw.Printf("move %s %d", window, plus)
-	return err
+	return lw.command("move", window, plus)
 }
 
 // Truncate cuts off the window contents of `window` at the
 // absolute offset `cutoff`. Lines above will be cleared.
 func (lw *LineWriter) Truncate(window string, cutoff int) error {
-	_, err := lw.Printf("truncate %s %d", window, cutoff)
-	return err
+	return lw.command("truncate", window, cutoff)
+}
+
+// Batch executes `fn` as atomic update of `window`: Changes made to the
+// window by `fn` become visible together once it returns.
+// The batch is also closed if `fn` fails; its error is returned then.
+func (lw *LineWriter) Batch(window string, fn func() error) error {
+	if err := lw.command("begin", window); err != nil {
+		return err
+	}
+
+	fnErr := fn()
+
+	if err := lw.command("commit", window); err != nil && fnErr == nil {
+		return err
+	}
+
+	return fnErr
+}
+
+// Popup shows `window` on top of the active window for `duration`, after
+// which the previous contents are visible again. A zero `duration` keeps the
+// popup until Dismiss is called.
+func (lw *LineWriter) Popup(window string, duration time.Duration) error {
+	return lw.command("popup", window, duration)
+}
+
+// PopupRows is like Popup, but `window` only covers `rows` rows
+// of the display, starting at row `row`.
+func (lw *LineWriter) PopupRows(window string, duration time.Duration, row, rows int) error {
+	return lw.command("popup", window, duration, row, rows)
+}
+
+// Dismiss removes the popup showing `window`.
+func (lw *LineWriter) Dismiss(window string) error {
+	return lw.command("dismiss", window)
 }
 
 // Quit makes displayd quit.
 func (lw *LineWriter) Quit() error {
-	_, err := lw.Printf("quit")
+	// quit is only answered in the framed protocol:
+	if lw.framed {
+		return lw.command("quit")
+	}
+
+	_, err := lw.Write([]byte("quit"))
 	return err
 }
 
+// Glyph makes `rn` look like `glyph` when written to `window`.
+// displayd picks a free programmable slot of the LCD for it.
+func (lw *LineWriter) Glyph(window string, rn rune, glyph Glyph) error {
+	return lw.command("glyph", window, string(rn), glyph)
+}
+
+// DefineGlyph loads `glyph` directly into the programmable `slot` (0-7).
+func (lw *LineWriter) DefineGlyph(slot int, glyph Glyph) error {
+	return lw.command("glyph", slot, glyph)
+}
+
+// Backlight sets the backlight of the display to `level` (0-100).
+// displayd might still dim it when idle or at night.
+func (lw *LineWriter) Backlight(level int) error {
+	return lw.command("backlight", level)
+}
+
+// Contrast sets the contrast of the display to `level` (0-100).
+func (lw *LineWriter) Contrast(level int) error {
+	return lw.command("contrast", level)
+}
+
+// Input tells displayd that the user just did something (like turning the
+// knob), so a dimmed backlight goes back to normal.
+func (lw *LineWriter) Input() error {
+	return lw.command("input")
+}
+
+// Destroy removes `window` from displayd.
+func (lw *LineWriter) Destroy(window string) error {
+	return lw.command("destroy", window)
+}
+
 // Render returns a display of the current active window.
 func (lw *LineWriter) Render() ([]byte, error) {
-	buf := &bytes.Buffer{}
+	return lw.output("render")
+}
 
-	if _, err := lw.Printf("render"); err != nil {
+// WindowInfo describes a single window known to displayd.
+type WindowInfo struct {
+	// Name is the name of the window.
+	Name string
+
+	// Owner is the id of the connection that created the window.
+	// It is 0 if the owner is gone already.
+	Owner int
+
+	// Lines is the number of lines in the window.
+	Lines int
+
+	// Active is true if the window is currently shown.
+	Active bool
+}
+
+// List returns all windows known to displayd, sorted by name.
+func (lw *LineWriter) List() ([]WindowInfo, error) {
+	data, err := lw.output("list")
+	if err != nil {
 		return nil, err
 	}
 
-	sizeBuf := make([]byte, 8)
-	n, err := lw.Read(sizeBuf)
-	if n != 8 {
-		log.Printf("Bad size header (%d != 8)", n)
-		return nil, fmt.Errorf("Bad size header")
+	infos := []WindowInfo{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+
+		split := strings.SplitN(line, " ", 4)
+		if len(split) < 4 {
+			return nil, fmt.Errorf("Bad window info `%s`", line)
+		}
+
+		info := WindowInfo{Name: split[3], Active: split[2] == "1"}
+		if info.Owner, err = strconv.Atoi(split[0]); err != nil {
+			return nil, err
+		}
+
+		if info.Lines, err = strconv.Atoi(split[1]); err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, info)
 	}
 
+	return infos, nil
+}
+
+// OutputInfo describes an output of displayd as returned by Outputs.
+type OutputInfo struct {
+	Name          string
+	Width, Height int
+	Encoding      string
+
+	// Driver is the health of the driver, e.g. "up", "down" or "internal".
+	Driver string
+
+	// Active is the active window of the output ("" if none).
+	Active string
+}
+
+// Outputs returns all outputs of displayd, the DefaultOutput first.
+func (lw *LineWriter) Outputs() ([]OutputInfo, error) {
+	data, err := lw.output("outputs")
 	if err != nil {
-		log.Printf("Reading size header failed: %v", err)
 		return nil, err
 	}
 
-	size := binary.LittleEndian.Uint64(sizeBuf)
-	if _, err := io.CopyN(buf, lw, int64(size)); err != nil {
-		return nil, err
+	infos := []OutputInfo{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+
+		split := strings.SplitN(line, " ", 5)
+		if len(split) < 5 {
+			return nil, fmt.Errorf("Bad output info `%s`", line)
+		}
+
+		info := OutputInfo{Name: split[0], Encoding: split[2], Driver: split[3], Active: split[4]}
+		if _, err := fmt.Sscanf(split[1], "%dx%d", &info.Width, &info.Height); err != nil {
+			return nil, fmt.Errorf("Bad output size `%s`", split[1])
+		}
+
+		infos = append(infos, info)
 	}
 
-	return buf.Bytes(), nil
+	return infos, nil
 }
 
 // Close cancels all pending operations and frees resources.
 func (lw *LineWriter) Close() error {
+	// Cancel first; a retry loop holding the lock would never end otherwise:
 	lw.cancel()
-	return lw.conn.Close()
+
+	lw.Lock()
+	defer lw.Unlock()
+
+	// We might have never been connected:
+	if lw.conn == nil {
+		return nil
+	}
+
+	conn := lw.conn
+	lw.conn = nil
+	return conn.Close()
 }
 
-func (lw *LineWriter) reconnect() error {
-	addr := fmt.Sprintf("%s:%d", lw.host, lw.port)
-	conn, err := net.Dial("tcp", addr)
+// reconnect replaces the connection by a new one. It must be called with
+// the lock held. On errors lw.conn is left nil.
+func (lw *LineWriter) reconnect() (err error) {
+	if lw.conn != nil {
+		util.Closer(lw.conn)
+		lw.conn = nil
+	}
+
+	conn, err := util.Dial(lw.host, lw.port)
 	if err != nil {
 		return err
 	}
 
+	defer func() {
+		if err != nil {
+			util.Closer(conn)
+			lw.conn = nil
+		}
+	}()
+
 	lw.conn = conn
+	lw.reader = bufio.NewReader(conn)
+
+	handshake := ""
+	switch {
+	case lw.framed:
+		handshake = fmt.Sprintf("proto %s %d\n", FramedProtocol, FramedVersion)
+	case lw.ack:
+		handshake = "ack on\n"
+	}
+
+	if handshake != "" {
+		if _, err := conn.Write([]byte(handshake)); err != nil {
+			return err
+		}
+
+		reply, err := lw.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		if err := parseReply(reply); err != nil {
+			return err
+		}
+	}
+
+	if lw.token != "" {
+		if err := lw.setup("auth", lw.token); err != nil {
+			return err
+		}
+	}
+
+	if lw.outputName != "" {
+		return lw.setup("output", lw.outputName)
+	}
+
 	return nil
 }
 
+// setup sends a command with a single argument right after connecting,
+// where command() can not be used since it would reconnect on errors.
+func (lw *LineWriter) setup(cmd, arg string) error {
+	if !lw.framed {
+		if _, err := fmt.Fprintf(lw.conn, "%s %s\n", cmd, arg); err != nil || !lw.ack {
+			return err
+		}
+
+		reply, err := lw.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		return parseReply(reply)
+	}
+
+	rawArgs, err := frameArgs([]interface{}{arg})
+	if err != nil {
+		return err
+	}
+
+	lw.lastID++
+	data, err := json.Marshal(frameRequest{ID: lw.lastID, Cmd: cmd, Args: rawArgs})
+	if err != nil {
+		return err
+	}
+
+	if err := writeFramed(lw.conn, data); err != nil {
+		return err
+	}
+
+	respData, err := readFramed(lw.reader)
+	if err != nil {
+		return err
+	}
+
+	_, err = parseFrameResponse(respData, lw.lastID)
+	return err
+}
+
 func (lw *LineWriter) retryUntilSuccesfull() {
 	for {
 		select {
@@ -172,7 +637,12 @@ func (lw *LineWriter) retryUntilSuccesfull() {
 		if err := lw.reconnect(); err != nil {
 			log.Printf("Failed to connect to displayd: %v", err)
 			log.Printf("Retry in 5 seconds")
-			time.Sleep(5 * time.Second)
+
+			select {
+			case <-lw.ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+
 			continue
 		}
 
@@ -187,14 +657,54 @@ func Connect(cfg *Config, ctx context.Context) (*LineWriter, error) {
 	lw := &LineWriter{
 		host:   cfg.Host,
 		port:   cfg.Port,
+		ack:    cfg.Acknowledged,
+		framed: cfg.Framed,
 		ctx:    subCtx,
 		cancel: cancel,
+
+		outputName: cfg.Output,
+		token:      cfg.Token,
 	}
 
 	lw.retryUntilSuccesfull()
 	return lw, nil
 }
 
+// Notify shows `lines` in a popup window named `window` for `duration`.
+// The popup covers only as many rows as needed, starting at the top.
+// It gives up with an error if displayd is not reachable before `ctx` is done.
+func Notify(cfg *Config, ctx context.Context, window string, duration time.Duration, lines ...string) error {
+	ackCfg := *cfg
+	ackCfg.Acknowledged = true
+
+	lw, err := Connect(&ackCfg, ctx)
+	if err != nil {
+		return err
+	}
+
+	defer util.Closer(lw)
+
+	if cancelled(ctx) {
+		return ctx.Err()
+	}
+
+	err = lw.Batch(window, func() error {
+		for idx, line := range lines {
+			if err := lw.Line(window, idx, line); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return lw.PopupRows(window, duration, 0, len(lines))
+}
+
 func cancelled(ctx context.Context) bool {
 	select {
 	case <-ctx.Done():
@@ -212,7 +722,7 @@ func DumpClient(cfg *Config, ctx context.Context, window string, update bool) er
 		return err
 	}
 
-	if _, err := lw.Printf("switch %s", window); err != nil {
+	if err := lw.Switch(window); err != nil {
 		return err
 	}
 
@@ -242,6 +752,67 @@ func DumpClient(cfg *Config, ctx context.Context, window string, update bool) er
 	return nil
 }
 
+// OutputsClient prints all outputs of displayd onto stdout.
+func OutputsClient(cfg *Config, ctx context.Context) error {
+	lw, err := Connect(cfg, ctx)
+	if err != nil {
+		return err
+	}
+
+	defer lw.Close()
+
+	infos, err := lw.Outputs()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		active := info.Active
+		if active == "" {
+			active = "-"
+		}
+
+		fmt.Printf(
+			"%-10s %3dx%-2d  %-6s driver: %-8s window: %s\n",
+			info.Name, info.Width, info.Height, info.Encoding, info.Driver, active,
+		)
+	}
+
+	return nil
+}
+
+// ListClient prints all windows known to displayd onto stdout.
+// The active window is marked with a star.
+func ListClient(cfg *Config, ctx context.Context) error {
+	lw, err := Connect(cfg, ctx)
+	if err != nil {
+		return err
+	}
+
+	defer lw.Close()
+
+	infos, err := lw.List()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		mark := " "
+		if info.Active {
+			mark = "*"
+		}
+
+		owner := "-"
+		if info.Owner != 0 {
+			owner = strconv.Itoa(info.Owner)
+		}
+
+		fmt.Printf("%s %-20s %5d lines  owner: %s\n", mark, info.Name, info.Lines, owner)
+	}
+
+	return nil
+}
+
 // InputClient is a dump client that can be used to send arbitrary displayd
 // lines in a netcat or telnet like fashion from the commandline.
 func InputClient(cfg *Config, ctx context.Context, quit bool, window string) error {
@@ -21,15 +21,47 @@
 //    0 Text at line 0 (first line)
 //    1,5 Text at line 1 and offset 5
 //
+// Lines starting with "!" are control messages for the driver:
+//
+//    !glyph <slot> <bitmap>  -- Load a 5x8 bitmap into programmable slot 0-7.
+//    !backlight <level>      -- Set the backlight to <level> percent.
+//    !contrast <level>       -- Set the contrast to <level> percent.
+//
+// displayd restarts the driver when it exits (with a growing delay between
+// attempts) and logs its stdout and stderr. A restarted driver gets the
+// loaded glyphs, the levels and a full frame again. The <driver> column of
+// `outputs` tells if it is "up" or "down" and how often it was restarted.
+//
 // It is expected that the driver manages to not re-render unchanged areas.
-// Displayd will then print the LCD matrix to the driver periodically.
-// Why no event based loop? because the usual usecase of several scrolling
-// lines will actually update more often than a polling based approach.
+// Displayd itself only sends something when the visible part of the active
+// window changed (by new text, scrolling, moving or switching windows).
+// Unchanged rows are skipped and partially changed rows are sent with an
+// offset, so the driver has as little work as possible.
+//
+// For testing without real hardware, displayd can use an in-process VirtualLCD
+// (driver name "virtual") that keeps the LCD matrix in memory and records every
+// frame, so rendered output can be compared against golden files.
+//
+// If Config.HTTPAddr is set, displayd also serves a live mirror of the display
+// to browsers. The page at "/" draws the display and is updated via
+// Server-Sent Events from "/events"; "/frame" returns the current frame as JSON.
+// With tokens set, browsers need read permission and pass "?token=<token>".
 //
 // By it's architecture it also enables the simultaneous write to the display
 // in an ordered fashion and makes it easy for the programmer to use normal
 // utf8 encoding while silently subsituting it with lcd compatible codepoints.
 //
+// Which codepoints those are depends on the ROM of the display; the Encoder
+// is chosen by name via Config.Encoding:
+//
+//    a02    -- The european ROM of the eulenfunk LCD (default).
+//    a00    -- The japanese ROM that most HD44780 displays ship with.
+//    ascii  -- Plain ASCII only.
+//    utf8   -- No conversion; for terminals and displays that can show utf8.
+//
+// Characters the ROM does not have are transliterated if possible (ä -> ae,
+// € -> EUR), otherwise they are shown as '?'.
+//
 // displayd is controlled by a simple line based text protocol and supports
 // currently the following commands:
 //
@@ -40,7 +72,104 @@
 //    render                     -- Outputs the current active window to the socket.
 //    close                      -- Terminates the connection.
 //    quit                       -- Terminates displayd.
+//    auth <token>               -- Authenticate with <token>.
 //    scroll <win> <pos> <delay> -- Make line <pos> of <win> scrolled with speed <delay>
 //                                  (default: 0 -> disabled)
+//    attr <win> <pos> <key>=<value>...
+//                               -- Set layout attributes of line <pos> in <win>:
+//                                  align=left|center|right  (for short text)
+//                                  scroll=wrap|bounce|once  (for long text)
+//                                  pause=<duration>         (rest at the start)
+//                                  fill=<char>              (pad short text)
+//    widget <win> <pos> <kind> [<spec>]
+//                               -- Let displayd generate line <pos> in <win>:
+//                                  clock [<layout>]         (Go time layout)
+//                                  timer <span> [<format>]  (%e, %r, %t, %%)
+//                                  progress <span> [<char>] (bar of <char>)
+//                                  none                     (stop generating)
+//                                  <span> is "<elapsed> <duration> running|paused".
+//                                  Writing the line with `line` also stops it.
+//    ack [on|off]               -- Enable (default) or disable acknowledged mode.
+//    begin <win>                -- Start a batch; changes to <win> are not shown...
+//    commit <win>               -- ...until the matching commit (may be nested).
+//    popup <win> <duration> [<row> [<rows>]]
+//                               -- Show <win> on top of the active window for
+//                                  <duration> (0s: until dismissed), covering
+//                                  <rows> rows from <row> (default: all).
+//    dismiss <win>              -- Remove the popup showing <win> early.
+//    destroy <win>              -- Remove <win> and all of its lines.
+//    list                       -- Outputs all windows to the socket, one per line:
+//                                  <owner> <lines> <active> <name>
+//    glyph <slot> <bitmap>      -- Load <bitmap> into programmable slot <slot> (0-7).
+//    glyph <win> <char> <bitmap>
+//                               -- Make <char> look like <bitmap> in <win>.
+//    backlight <level>          -- Set the backlight level (0-100).
+//    contrast <level>           -- Set the contrast level (0-100).
+//    input                      -- Report user input (e.g. a turned knob).
+//    output <name>              -- Send all further commands to output <name>.
+//    outputs                    -- Outputs all outputs to the socket, one per line:
+//                                  <name> <width>x<height> <encoding> <driver> <active>
+//
+// One displayd can drive several displays (see Config.Outputs). Each output
+// has its own size, encoding, windows and active window. Connections start on
+// the DefaultOutput ("main"); `input` applies to all outputs at once.
+//
+// The backlight is dimmed to Config.DimLevel when no input was reported for
+// Config.DimAfter, until the next input. During Config.Night it is at most
+// Config.NightLevel. `backlight` sets the level when not dimmed.
+//
+// When the display did not change and no command or input was reported for
+// Config.IdleAfter, a screensaver is shown instead of all windows (see
+// ScreensaverModes). The windows are shown again on the next input or
+// client command that needs control permission; reading commands like
+// render, list and outputs do not count, so polling clients do not keep
+// the screensaver away.
+//
+// With Config.RecordFile every successful command is written to a session
+// file, one JSON object per line with its time, connection id, command and
+// arguments.
+// `eulenfunk display replay <file>` plays it back into a running displayd
+// (e.g. one with the `cat` driver) at the recorded or a faster speed.
+//
+// Bitmaps are given as 8 comma separated hex rows (00-1f), top to bottom.
+// The LCD has only 8 programmable slots; by default they contain the custom
+// chars of eulenfunk (━ ▶ ⏸ ❤ × ✓ ⏹ 🌵). Glyphs of windows take those slots
+// from the end and give them back when the window is destroyed.
+//
+// The first connection that uses a window becomes its owner. When the owner
+// disconnects, its windows are destroyed if Config.CollectWindows is set.
+// Otherwise they are kept with owner 0, so other clients can still use them.
+//
+// If Config.ControlToken is set, only connections that sent it with `auth`
+// may change anything. `render`, `list`, `outputs` and `output` only need
+// Config.ReadToken, or nothing if that is empty. Other commands fail with
+// ErrCodeDenied. Clients send Config.Token on every connect.
+//
+// In acknowledged mode every command is answered by a single line:
+//
+//    OK                         -- The command was executed.
+//    ERR <code> <message>       -- The command failed; see the ErrCode* constants.
+//
+// `render`, `list` and `outputs` reply with their output instead, prefixed by its size as
+// 8 byte little endian. `close` and `quit` are never answered.
+// The `ack` command itself is always answered.
+//
+// The text protocol is handy for netcat, but window names can not contain
+// spaces and text can not contain newlines. Other clients should switch to the
+// framed protocol right after connecting (answered like `ack`):
+//
+//    proto json 1
+//
+// Afterwards every message in both directions is a JSON document, prefixed
+// by its size as 8 byte little endian. Every request gets exactly one response:
+//
+//    {"id": 1, "cmd": "line", "args": ["my window", 0, "some text"]}
+//    {"id": 1, "ok": true}
+//    {"id": 2, "cmd": "list", "args": []}
+//    {"id": 2, "ok": true, "data": "1 4 1 my window\n"}
+//    {"id": 3, "cmd": "move", "args": ["my window"]}
+//    {"id": 3, "ok": false, "code": 2, "error": "Usage: move <win> <n>"}
+//
+// The commands and their arguments are the same as in the text protocol.
 //
 package display
@@ -19,21 +19,17 @@ var (
 		'σ', 'τ', 'ʊ', 'φ', 'ψ', 'ω', '▾', '▸', '◂', '𝐑', '⥒', '𝐅', '⥓', '▯', '━', '⧌', // 240 - 255
 	}
 	// Custom chars of eulenfunk; 0-7 is the same as 8-15.
+	// Those are only the defaults, see defaultGlyphs and glyphSlot.
 	customChars = []rune{
 		'━', '▶', '⏸', '❤', '×', '✓', '⏹', '🌵',
-		'━', //'▶', '⏸', '❤', '×', '✓', '⏹', '🌵',
 	}
 )
 
-// Mapping from utf8 characters to LCD codepoint.
+// Mapping from utf8 characters to LCD codepoint of the A02 ROM.
 // Gets populated in init()
 var utf8ToLCD = map[rune]rune{}
 
 func init() {
-	for idx, rn := range customChars {
-		utf8ToLCD[rn] = rune(idx)
-	}
-
 	for idx, rn := range specialASCII {
 		utf8ToLCD[rn] = rune(16 + idx)
 	}
@@ -43,22 +39,20 @@ func init() {
 	}
 }
 
-func encode(s string) []rune {
+// encode converts `s` to codepoints of the display using `enc`.
+// `glyphs` maps characters to the programmable slots of the LCD
+// and is checked first.
+func encode(s string, enc Encoder, glyphs map[rune]rune) []rune {
 	// Iterate by rune:
 	encoded := []rune{}
 
 	for _, rn := range s {
-		b, ok := utf8ToLCD[rn]
-		if !ok {
-			if rn > 255 {
-				// Multibyte chars would be messed up anyways:
-				b = '?'
-			} else {
-				b = rn
-			}
+		if b, ok := glyphs[rn]; ok {
+			encoded = append(encoded, b)
+			continue
 		}
 
-		encoded = append(encoded, b)
+		encoded = append(encoded, enc.Encode(rn)...)
 	}
 
 	return encoded
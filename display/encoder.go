@@ -0,0 +1,227 @@
+package display
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultEncoding is the encoding used if none was given.
+const DefaultEncoding = "a02"
+
+// Encoder converts utf8 text to the character set of a certain display.
+type Encoder interface {
+	// Encode converts `rn` to one or more codepoints of the display.
+	Encode(rn rune) []rune
+
+	// Output converts encoded codepoints to the bytes sent to the driver.
+	Output(encoded []rune) []byte
+
+	// Decode converts encoded codepoints back to readable utf8.
+	// Transliterations can not be undone.
+	Decode(encoded []rune) string
+}
+
+var encoders = map[string]Encoder{
+	// The ROM of our LCD; see nonASCII and specialASCII.
+	"a02": &romEncoder{table: utf8ToLCD, latin1: true},
+
+	// The japanese ROM that most HD44780 clones ship with.
+	"a00": &romEncoder{table: utf8ToA00, missing: "\\~"},
+
+	// Plain ASCII, e.g. for very simple displays.
+	"ascii": &romEncoder{},
+
+	// Passthrough for terminals and displays that can render utf8.
+	"utf8": utf8Encoder{},
+}
+
+// EncoderNames returns the names of all encoders, sorted.
+func EncoderNames() []string {
+	names := []string{}
+	for name := range encoders {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// LookupEncoder returns the encoder called `name`.
+// If `name` is empty, the DefaultEncoding is used.
+func LookupEncoder(name string) (Encoder, error) {
+	if name == "" {
+		name = DefaultEncoding
+	}
+
+	enc, ok := encoders[name]
+	if !ok {
+		return nil, fmt.Errorf(
+			"No such encoding `%s` (choose from: %s)",
+			name, strings.Join(EncoderNames(), ", "),
+		)
+	}
+
+	return enc, nil
+}
+
+// romEncoder encodes text for a HD44780 style character ROM.
+// Characters the ROM does not have are transliterated if possible.
+type romEncoder struct {
+	// table maps utf8 characters beyond ASCII to ROM codepoints.
+	table map[rune]rune
+
+	// missing are printable ASCII characters the ROM has no glyph for.
+	missing string
+
+	// latin1 passes runes up to 255 unchanged if they are not in table.
+	latin1 bool
+
+	// reverse is the inverse of table; built on the first Decode.
+	reverse     map[rune]rune
+	reverseOnce sync.Once
+}
+
+func (re *romEncoder) lookup(rn rune) (rune, bool) {
+	if b, ok := re.table[rn]; ok {
+		return b, true
+	}
+
+	switch {
+	case rn == '\n' || rn == '\r':
+		// Those would break the driver protocol.
+		return 0, false
+	case rn < 32:
+		// Control codes; 0-15 are the programmable characters.
+		return rn, true
+	case rn < 127:
+		return rn, !strings.ContainsRune(re.missing, rn)
+	case re.latin1 && rn <= 255:
+		return rn, true
+	}
+
+	return 0, false
+}
+
+func (re *romEncoder) Encode(rn rune) []rune {
+	if b, ok := re.lookup(rn); ok {
+		return []rune{b}
+	}
+
+	if repl, ok := transliterations[rn]; ok {
+		encoded := []rune{}
+		for _, replRn := range repl {
+			b, ok := re.lookup(replRn)
+			if !ok {
+				encoded = nil
+				break
+			}
+
+			encoded = append(encoded, b)
+		}
+
+		if encoded != nil {
+			return encoded
+		}
+	}
+
+	return []rune{'?'}
+}
+
+func (re *romEncoder) Output(encoded []rune) []byte {
+	out := make([]byte, len(encoded))
+	for idx, rn := range encoded {
+		out[idx] = byte(rn)
+	}
+
+	return out
+}
+
+func (re *romEncoder) Decode(encoded []rune) string {
+	re.reverseOnce.Do(func() {
+		re.reverse = make(map[rune]rune)
+		for rn, code := range re.table {
+			re.reverse[code] = rn
+		}
+
+		// Assume the default glyphs are loaded:
+		for idx, rn := range customChars {
+			re.reverse[slotCode(idx)] = rn
+		}
+	})
+
+	decoded := []rune{}
+	for _, code := range encoded {
+		rn, ok := re.reverse[code]
+		if !ok {
+			rn = '?'
+			if code >= 32 && code < 127 && !strings.ContainsRune(re.missing, code) {
+				rn = code
+			}
+		}
+
+		decoded = append(decoded, rn)
+	}
+
+	return string(decoded)
+}
+
+// utf8Encoder passes text unchanged. Offsets sent to the driver are still
+// counted in characters, not in bytes.
+type utf8Encoder struct{}
+
+func (ue utf8Encoder) Encode(rn rune) []rune {
+	if rn == '\n' || rn == '\r' {
+		return []rune{' '}
+	}
+
+	return []rune{rn}
+}
+
+func (ue utf8Encoder) Output(encoded []rune) []byte {
+	return []byte(string(encoded))
+}
+
+func (ue utf8Encoder) Decode(encoded []rune) string {
+	return string(encoded)
+}
+
+// Mapping from utf8 characters to LCD codepoints of the A00 ROM.
+// The katakana are added in init()
+var utf8ToA00 = map[rune]rune{
+	'¥': 0x5c, '→': 0x7e, '←': 0x7f,
+	'α': 0xe0, 'ä': 0xe1, 'β': 0xe2, 'ε': 0xe3, 'μ': 0xe4, 'σ': 0xe5, 'ρ': 0xe6,
+	'√': 0xe8, '¢': 0xec, '£': 0xed, 'ñ': 0xee, 'ö': 0xef, 'θ': 0xf2, '∞': 0xf3,
+	'Ω': 0xf4, 'ü': 0xf5, 'Σ': 0xf6, 'π': 0xf7, '千': 0xfa, '万': 0xfb, '円': 0xfc,
+	'÷': 0xfd, '█': 0xff,
+}
+
+func init() {
+	// Halfwidth katakana are in the same order as in JIS X 0201:
+	for rn := '｡'; rn <= 'ﾟ'; rn++ {
+		utf8ToA00[rn] = 0xa1 + (rn - '｡')
+	}
+}
+
+// Replacements for characters a ROM does not have.
+var transliterations = map[rune]string{
+	'ä': "ae", 'ö': "oe", 'ü': "ue", 'Ä': "Ae", 'Ö': "Oe", 'Ü': "Ue", 'ß': "ss",
+	'á': "a", 'à': "a", 'â': "a", 'å': "a", 'ã': "a", 'ā': "a",
+	'Á': "A", 'À': "A", 'Â': "A", 'Å': "A", 'Ã': "A",
+	'é': "e", 'è': "e", 'ê': "e", 'ë': "e", 'ė': "e", 'É': "E", 'È': "E", 'Ê': "E",
+	'í': "i", 'ì': "i", 'î': "i", 'ï': "i", 'Í': "I", 'Ì': "I", 'Î': "I",
+	'ó': "o", 'ò': "o", 'ô': "o", 'õ': "o", 'ø': "o", 'ō': "o",
+	'Ó': "O", 'Ò': "O", 'Ô': "O", 'Õ': "O", 'Ø': "O",
+	'ú': "u", 'ù': "u", 'û': "u", 'Ú': "U", 'Ù': "U", 'Û': "U",
+	'ç': "c", 'Ç': "C", 'ñ': "n", 'Ñ': "N", 'ÿ': "y", 'ý': "y", 'Ý': "Y",
+	'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ł': "l", 'Ł': "L",
+	'š': "s", 'Š': "S", 'ž': "z", 'Ž': "Z", 'č': "c", 'Č': "C", 'ř': "r", 'Ř': "R",
+	'‘': "'", '’': "'", '‚': ",", '“': "\"", '”': "\"", '„': "\"", '«': "<<", '»': ">>",
+	'–': "-", '—': "-", '…': "...", '•': "*", '·': ".", '×': "x", '÷': "/",
+	'€': "EUR", '£': "GBP", '¥': "JPY", '©': "(c)", '®': "(R)", '™': "TM",
+	'°': "o", '±': "+-", '½': "1/2", '¼': "1/4", '¾': "3/4", '²': "2", '³': "3",
+	'→': "->", '←': "<-", '≤': "<=", '≥': ">=", '≠': "!=", '≈': "~",
+	'━': "-", '▶': ">", '⏸': "||", '⏹': "#", '❤': "<3", '✓': "v",
+	'\u00a0': " ", '\n': " ", '\r': " ",
+}
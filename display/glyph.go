@@ -0,0 +1,229 @@
+package display
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// NGlyphSlots is the number of programmable characters (CGRAM) of the LCD.
+const NGlyphSlots = 8
+
+// Glyph is the 5x8 bitmap of a programmable character.
+// Each byte is one row from top to bottom; only the lower 5 bits are used.
+type Glyph [8]byte
+
+// Glyphs that are loaded by the driver on startup; see driver/lcd-driver.c.
+// Their utf8 counterparts are in customChars.
+var defaultGlyphs = [NGlyphSlots]Glyph{
+	{0x00, 0x00, 0x00, 0x1f, 0x1f, 0x00, 0x00, 0x00}, // ━
+	{0x00, 0x10, 0x18, 0x1c, 0x1e, 0x1c, 0x18, 0x10}, // ▶
+	{0x00, 0x1b, 0x1b, 0x1b, 0x1b, 0x1b, 0x1b, 0x00}, // ⏸
+	{0x00, 0x00, 0x0a, 0x1f, 0x1f, 0x0e, 0x04, 0x00}, // ❤
+	{0x11, 0x1b, 0x0e, 0x04, 0x0e, 0x1b, 0x11, 0x00}, // ×
+	{0x00, 0x01, 0x03, 0x16, 0x1c, 0x08, 0x00, 0x00}, // ✓
+	{0x00, 0x00, 0x00, 0x0e, 0x0e, 0x0e, 0x00, 0x00}, // ⏹
+	{0x00, 0x01, 0x05, 0x15, 0x16, 0x0c, 0x04, 0x04}, // 🌵
+}
+
+// ParseGlyph parses a bitmap given as 8 comma separated hex rows,
+// e.g. "00,00,00,1f,1f,00,00,00" for a horizontal bar.
+func ParseGlyph(spec string) (Glyph, error) {
+	glyph := Glyph{}
+
+	rows := strings.Split(spec, ",")
+	if len(rows) != len(glyph) {
+		return glyph, fmt.Errorf("Glyph needs %d rows, got %d", len(glyph), len(rows))
+	}
+
+	for idx, row := range rows {
+		bits, err := strconv.ParseUint(strings.TrimSpace(row), 16, 8)
+		if err != nil || bits > 0x1f {
+			return glyph, fmt.Errorf("Bad glyph row `%s` (00-1f expected)", row)
+		}
+
+		glyph[idx] = byte(bits)
+	}
+
+	return glyph, nil
+}
+
+// String returns the glyph in the format understood by ParseGlyph.
+func (g Glyph) String() string {
+	rows := make([]string, len(g))
+	for idx, bits := range g {
+		rows[idx] = fmt.Sprintf("%02x", bits)
+	}
+
+	return strings.Join(rows, ",")
+}
+
+// glyphSlot describes what is currently loaded into one CGRAM slot.
+type glyphSlot struct {
+	Glyph Glyph
+
+	// Rune is the utf8 character that is shown using this slot.
+	// It is 0 if the slot was defined without a character.
+	Rune rune
+
+	// Window is the window that allocated the slot.
+	// It is empty if the slot is shared by all windows.
+	Window string
+}
+
+// slotCode returns the LCD codepoint of `slot`. The codepoints 8-15 mirror
+// 0-7, but 0 would end the text and 10 the whole line for the driver.
+func slotCode(slot int) rune {
+	if slot == 0 {
+		return NGlyphSlots
+	}
+
+	return rune(slot)
+}
+
+func (srv *server) initGlyphs() {
+	for slot := range srv.Glyphs {
+		srv.Glyphs[slot] = glyphSlot{
+			Glyph: defaultGlyphs[slot],
+			Rune:  customChars[slot],
+		}
+	}
+}
+
+// charset returns the mapping of utf8 characters to slots for window `name`.
+func (srv *server) charset(name string) map[rune]rune {
+	// Terminals have no programmable characters:
+	if _, ok := srv.Encoder.(utf8Encoder); ok {
+		return nil
+	}
+
+	glyphs := make(map[rune]rune)
+
+	for slot, gs := range srv.Glyphs {
+		if gs.Rune != 0 && gs.Window == "" {
+			glyphs[gs.Rune] = slotCode(slot)
+		}
+	}
+
+	// Characters of the window itself take precedence:
+	for slot, gs := range srv.Glyphs {
+		if gs.Rune != 0 && gs.Window == name {
+			glyphs[gs.Rune] = slotCode(slot)
+		}
+	}
+
+	return glyphs
+}
+
+// decode converts `encoded` back to readable utf8. Unlike Encoder.Decode
+// it knows which characters are currently loaded into the glyph slots.
+func (srv *server) decode(encoded []rune) string {
+	if _, ok := srv.Encoder.(utf8Encoder); ok {
+		return srv.Encoder.Decode(encoded)
+	}
+
+	decoded := []rune{}
+	for _, code := range encoded {
+		if code < 2*NGlyphSlots {
+			rn := srv.Glyphs[code%NGlyphSlots].Rune
+			if rn == 0 {
+				// Defined without a character:
+				rn = '?'
+			}
+
+			decoded = append(decoded, rn)
+			continue
+		}
+
+		decoded = append(decoded, []rune(srv.Encoder.Decode([]rune{code}))...)
+	}
+
+	return string(decoded)
+}
+
+// refreshCharsets re-encodes all windows after the slots changed.
+func (srv *server) refreshCharsets() {
+	for name, win := range srv.Windows {
+		win.SetGlyphs(srv.charset(name))
+	}
+}
+
+// sendGlyph sends the glyph in `slot` to the driver.
+func (srv *server) sendGlyph(slot int) {
+	if _, err := fmt.Fprintf(srv.DriverPipe, "!glyph %d %s\n", slot, srv.Glyphs[slot].Glyph); err != nil {
+		log.Printf("Failed to send glyph to driver: %v", err)
+	}
+}
+
+// loadGlyph puts `gs` into `slot` and sends it to the driver.
+func (srv *server) loadGlyph(slot int, gs glyphSlot) {
+	srv.Glyphs[slot] = gs
+	srv.sendGlyph(slot)
+
+	srv.refreshCharsets()
+	srv.touch()
+}
+
+// DefineGlyph loads `glyph` into `slot`, regardless of any character.
+// The slot can then only be used by writing its codepoint directly.
+func (srv *server) DefineGlyph(slot int, glyph Glyph) error {
+	srv.Lock()
+	defer srv.Unlock()
+
+	if slot < 0 || slot >= NGlyphSlots {
+		return fmt.Errorf("Bad glyph slot %d (0-%d)", slot, NGlyphSlots-1)
+	}
+
+	if owner := srv.Glyphs[slot].Window; owner != "" {
+		return fmt.Errorf("Glyph slot %d is used by window `%s`", slot, owner)
+	}
+
+	srv.loadGlyph(slot, glyphSlot{Glyph: glyph})
+	return nil
+}
+
+// AllocGlyph makes `rn` look like `glyph` inside the window `name`.
+// A free slot is taken from the end; the default glyphs in those slots are
+// not available anymore until the window is destroyed.
+func (srv *server) AllocGlyph(name string, rn rune, glyph Glyph) error {
+	srv.Lock()
+	defer srv.Unlock()
+
+	srv.lookupWindow(name)
+
+	free := -1
+	for slot := NGlyphSlots - 1; slot >= 0; slot-- {
+		gs := srv.Glyphs[slot]
+		if gs.Window == name && gs.Rune == rn {
+			// Already allocated, just redefine it:
+			free = slot
+			break
+		}
+
+		if gs.Window == "" && free < 0 {
+			free = slot
+		}
+	}
+
+	if free < 0 {
+		return fmt.Errorf("No free glyph slot left for `%c`", rn)
+	}
+
+	srv.loadGlyph(free, glyphSlot{Glyph: glyph, Rune: rn, Window: name})
+	return nil
+}
+
+// releaseGlyphs gives the slots of window `name` back to the default glyphs.
+func (srv *server) releaseGlyphs(name string) {
+	for slot, gs := range srv.Glyphs {
+		if gs.Window != name {
+			continue
+		}
+
+		srv.loadGlyph(slot, glyphSlot{
+			Glyph: defaultGlyphs[slot],
+			Rune:  customChars[slot],
+		})
+	}
+}
@@ -1,11 +1,40 @@
 package display
 
 import (
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 	"unicode/utf8"
 )
 
+// Possible values of Line.Align:
+const (
+	// AlignLeft puts short text at the start of the line (default).
+	AlignLeft = "left"
+
+	// AlignCenter puts short text in the middle of the line.
+	AlignCenter = "center"
+
+	// AlignRight puts short text at the end of the line.
+	AlignRight = "right"
+)
+
+// Possible values of Line.ScrollMode:
+const (
+	// ScrollWrap scrolls long text around, with a separator in between (default).
+	ScrollWrap = "wrap"
+
+	// ScrollBounce scrolls long text back and forth (ping-pong).
+	ScrollBounce = "bounce"
+
+	// ScrollOnce scrolls long text around once and stops at its start.
+	ScrollOnce = "once"
+)
+
+// scrollSeparator is shown between the end and the start of wrapping text.
+const scrollSeparator = "   ━❤━   "
+
 // Line is a fixed width buffer with scrolling support
 // It also supports special names for special symbols.
 type Line struct {
@@ -13,19 +42,61 @@ type Line struct {
 	Pos         int
 	ScrollDelay time.Duration
 
+	// Align defines where text shorter than the line is put.
+	Align string
+
+	// ScrollMode defines how text longer than the line scrolls.
+	ScrollMode string
+
+	// Pause is an extra delay whenever the scrolling text is at its start
+	// (and at its end with ScrollBounce).
+	Pause time.Duration
+
+	// Fill is used to pad aligned text; 0 means blank.
+	Fill rune
+
+	// raw is the text as it was passed to SetText.
+	raw string
+
+	// text is the encoded text; sep and fill are the encoded
+	// scrollSeparator and Fill.
 	text []rune
-	buf  []rune
+	sep  []rune
+	fill []rune
+
+	buf []rune
 
-	// current offset mod len(buf)
+	// current offset in text (+ sep)
 	scrollPos int
+
+	// direction of ScrollBounce; +1 or -1
+	scrollDir int
+
+	// true when ScrollOnce is done
+	scrollDone bool
+
+	// time of the last scroll shift
+	lastShift time.Time
+
+	// widget generates the text of the line, if set.
+	widget Widget
+
+	// time when the widget text changes next
+	widgetNext time.Time
+
+	// enc and glyphs as last passed to SetText; used to encode widget text.
+	enc    Encoder
+	glyphs map[rune]rune
 }
 
 // NewLine returns a new line at `pos`, `w` runes long.
 func NewLine(pos int, w int) *Line {
 	ln := &Line{
-		Pos:  pos,
-		text: []rune{},
-		buf:  make([]rune, w),
+		Pos:        pos,
+		Align:      AlignLeft,
+		ScrollMode: ScrollWrap,
+		text:       []rune{},
+		buf:        make([]rune, w),
 	}
 
 	// Initial render:
@@ -33,35 +104,148 @@ func NewLine(pos int, w int) *Line {
 	ln.redraw()
 	ln.Unlock()
 
-	go func() {
-		var delay time.Duration
-
-		for {
-			ln.Lock()
-			{
-				if ln.ScrollDelay == 0 {
-					delay = 200 * time.Millisecond
-					ln.scrollPos = 0
-				} else {
-					delay = ln.ScrollDelay
-					if len(ln.text) > 0 {
-						ln.scrollPos = (ln.scrollPos + 1) % len(ln.text)
-					}
-				}
-
-				ln.redraw()
-			}
-			ln.Unlock()
+	return ln
+}
+
+func (ln *Line) scrolling() bool {
+	return ln.ScrollDelay > 0 && len(ln.text) > len(ln.buf) && !ln.scrollDone
+}
+
+// resetScroll makes the line start scrolling from the start again.
+func (ln *Line) resetScroll() {
+	ln.scrollPos = 0
+	ln.scrollDir = 1
+	ln.scrollDone = false
+	ln.lastShift = time.Now()
+}
+
+// pausing returns true if the line is at a position where it pauses.
+func (ln *Line) pausing() bool {
+	if ln.ScrollMode == ScrollBounce && ln.scrollPos == len(ln.text)-len(ln.buf) {
+		return true
+	}
 
-			time.Sleep(delay)
+	return ln.scrollPos == 0
+}
+
+func (ln *Line) nextShift() time.Time {
+	delay := ln.ScrollDelay
+	if ln.pausing() {
+		delay += ln.Pause
+	}
+
+	return ln.lastShift.Add(delay)
+}
+
+func (ln *Line) shift() {
+	switch ln.ScrollMode {
+	case ScrollBounce:
+		max := len(ln.text) - len(ln.buf)
+		ln.scrollPos += ln.scrollDir
+
+		if ln.scrollPos >= max {
+			ln.scrollPos, ln.scrollDir = max, -1
 		}
-	}()
 
-	return ln
+		if ln.scrollPos <= 0 {
+			ln.scrollPos, ln.scrollDir = 0, 1
+		}
+	default:
+		ln.scrollPos = (ln.scrollPos + 1) % (len(ln.text) + len(ln.sep))
+		if ln.scrollPos == 0 && ln.ScrollMode == ScrollOnce {
+			ln.scrollDone = true
+		}
+	}
+}
+
+// updateWidget renders the widget again if its text changed until `now`.
+func (ln *Line) updateWidget(now time.Time) bool {
+	if ln.widget == nil {
+		return false
+	}
+
+	if !ln.widgetNext.IsZero() && now.Before(ln.widgetNext) {
+		return false
+	}
+
+	width := len(ln.buf)
+	ln.widgetNext = ln.widget.Next(now, width)
+
+	text := ln.widget.Text(now, width)
+	if text == ln.raw {
+		return false
+	}
+
+	ln.setText(text, ln.enc, ln.glyphs)
+	return true
+}
+
+// Advance updates the widget of the line and shifts the line by one if it
+// is scrolling and the scroll delay passed since the last shift.
+// It returns true if the contents changed.
+func (ln *Line) Advance(now time.Time) bool {
+	ln.Lock()
+	defer ln.Unlock()
+
+	changed := ln.updateWidget(now)
+
+	if !ln.scrolling() || now.Before(ln.nextShift()) {
+		return changed
+	}
+
+	ln.shift()
+	ln.lastShift = now
+	ln.redraw()
+	return true
+}
+
+// NextShift returns the time when the line needs to be shifted or its
+// widget rendered next. If neither is needed, the zero time is returned.
+func (ln *Line) NextShift() time.Time {
+	ln.Lock()
+	defer ln.Unlock()
+
+	next := time.Time{}
+	if ln.widget != nil {
+		next = ln.widgetNext
+	}
+
+	if !ln.scrolling() {
+		return next
+	}
+
+	return earliest(next, ln.nextShift())
 }
 
 func (ln *Line) redraw() {
-	scroll(ln.buf, ln.text, ln.scrollPos)
+	for i := range ln.buf {
+		ln.buf[i] = 0
+	}
+
+	width := len(ln.buf)
+
+	switch {
+	case len(ln.text) <= width:
+		pad := 0
+		switch ln.Align {
+		case AlignCenter:
+			pad = width/2 - len(ln.text)/2
+		case AlignRight:
+			pad = width - len(ln.text)
+		}
+
+		if len(ln.fill) > 0 {
+			for i := range ln.buf {
+				ln.buf[i] = ln.fill[i%len(ln.fill)]
+			}
+		}
+
+		copy(ln.buf[pad:], ln.text)
+	case ln.ScrollMode == ScrollBounce:
+		copy(ln.buf, ln.text[ln.scrollPos:])
+	default:
+		scroll(ln.buf, append(append([]rune{}, ln.text...), ln.sep...), ln.scrollPos)
+	}
 }
 
 // Redraw makes sure the line is up-to-date.
@@ -73,36 +257,113 @@ func (ln *Line) Redraw() {
 	ln.redraw()
 }
 
-// SetText sets and updates the text of `Line`.  If `useEncoding` is false the
-// text is not converted to the special one-rune encoding of the LCD which is
-// useful for debugging on a normal terminal.
-func (ln *Line) SetText(text string, useEncoding bool) {
+// Text returns the text as it was last passed to SetText.
+func (ln *Line) Text() string {
 	ln.Lock()
 	defer ln.Unlock()
 
-	// Add a nice separtor symbol in between scroll borders:
-	if utf8.RuneCountInString(text) > len(ln.buf) {
-		text += "   ━❤━   "
-	}
+	return ln.raw
+}
 
-	var encodedText []rune
-	if useEncoding {
-		encodedText = encode(text)
-	} else {
-		// Just take the incoming encoding,
-		// might render weirdly on LCD though.
-		encodedText = []rune(text)
+// SetText sets and updates the text of `Line`. The text is converted to the
+// character set of the display by `enc`. `glyphs` maps characters to
+// programmable LCD slots.
+func (ln *Line) SetText(text string, enc Encoder, glyphs map[rune]rune) {
+	ln.Lock()
+	defer ln.Unlock()
+
+	ln.setText(text, enc, glyphs)
+}
+
+func (ln *Line) setText(text string, enc Encoder, glyphs map[rune]rune) {
+	ln.enc, ln.glyphs = enc, glyphs
+
+	ln.raw = text
+	encodedText := encode(text, enc, glyphs)
+
+	ln.sep = encode(scrollSeparator, enc, glyphs)
+	ln.fill = nil
+	if ln.Fill != 0 {
+		ln.fill = encode(string(ln.Fill), enc, glyphs)
 	}
 
 	// Check if we need to re-render...
 	if string(encodedText) != string(ln.text) {
-		ln.scrollPos = 0
+		ln.resetScroll()
 	}
 
 	ln.text = encodedText
 	ln.redraw()
 }
 
+// SetWidget makes `widget` generate the text of the line from now on.
+// A nil `widget` keeps the last text it generated.
+func (ln *Line) SetWidget(widget Widget, now time.Time) {
+	ln.Lock()
+	defer ln.Unlock()
+
+	ln.widget = widget
+	ln.widgetNext = time.Time{}
+	ln.updateWidget(now)
+}
+
+// SetAttr sets the attribute `key` to `value`. Possible attributes are:
+//
+//	align   -- AlignLeft, AlignCenter or AlignRight
+//	scroll  -- ScrollWrap, ScrollBounce or ScrollOnce
+//	pause   -- A duration like "1s"
+//	fill    -- A single character; empty for blank
+//
+// The text needs to be set again afterwards, so a changed Fill is encoded.
+func (ln *Line) SetAttr(key, value string) error {
+	ln.Lock()
+	defer ln.Unlock()
+
+	switch key {
+	case "align":
+		switch value {
+		case AlignLeft, AlignCenter, AlignRight:
+			ln.Align = value
+		default:
+			return fmt.Errorf("Bad alignment `%s`", value)
+		}
+	case "scroll":
+		switch value {
+		case ScrollWrap, ScrollBounce, ScrollOnce:
+			if value != ln.ScrollMode {
+				ln.ScrollMode = value
+				ln.resetScroll()
+			}
+		default:
+			return fmt.Errorf("Bad scroll mode `%s`", value)
+		}
+	case "pause":
+		pause, err := time.ParseDuration(value)
+		if err != nil || pause < 0 {
+			return fmt.Errorf("Bad pause `%s`", value)
+		}
+
+		ln.Pause = pause
+	case "fill":
+		fill, size := utf8.DecodeRuneInString(value)
+		if size != len(value) {
+			return fmt.Errorf("Bad fill `%s` (one character expected)", value)
+		}
+
+		// An empty value gives RuneError with size 0:
+		if size == 0 {
+			fill = 0
+		}
+
+		ln.Fill = fill
+	default:
+		return fmt.Errorf("Unknown line attribute `%s`", key)
+	}
+
+	ln.redraw()
+	return nil
+}
+
 // SetScrollDelay sets the scroll speed of the line (i.e. the delay between one
 // "shift"). Shorter delay means faster scrolling.
 func (ln *Line) SetScrollDelay(delay time.Duration) {
@@ -113,6 +374,10 @@ func (ln *Line) SetScrollDelay(delay time.Duration) {
 		ln.scrollPos = 0
 	}
 
+	if ln.ScrollDelay == 0 {
+		ln.resetScroll()
+	}
+
 	ln.ScrollDelay = delay
 	ln.redraw()
 }
@@ -142,3 +407,20 @@ func (ln *Line) Render() []rune {
 
 	return ln.buf
 }
+
+// parseAttrs splits "key=value" pairs separated by whitespace.
+func parseAttrs(specs []string) ([][2]string, error) {
+	attrs := [][2]string{}
+	for _, spec := range specs {
+		for _, field := range strings.Fields(spec) {
+			split := strings.SplitN(field, "=", 2)
+			if len(split) < 2 {
+				return nil, fmt.Errorf("Bad attribute `%s` (key=value expected)", field)
+			}
+
+			attrs = append(attrs, [2]string{split[0], split[1]})
+		}
+	}
+
+	return attrs, nil
+}
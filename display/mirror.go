@@ -0,0 +1,335 @@
+package display
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/studentkittens/eulenfunk/util"
+	"golang.org/x/net/context"
+)
+
+// mirrorFrame is what browsers watching the display get to see.
+type mirrorFrame struct {
+	// Active is the name of the active window ("" if there is none).
+	Active string `json:"active"`
+
+	// Popups are the names of the windows shown on top, topmost last.
+	Popups []string `json:"popups"`
+
+	// Rows are the rows of the display as readable utf8.
+	Rows []string `json:"rows"`
+
+	// Backlight is the level of the backlight (0-100).
+	Backlight int `json:"backlight"`
+}
+
+func (mf *mirrorFrame) equal(other *mirrorFrame) bool {
+	if other == nil || mf.Active != other.Active || mf.Backlight != other.Backlight {
+		return false
+	}
+
+	return equalStrings(mf.Popups, other.Popups) && equalStrings(mf.Rows, other.Rows)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// mirror streams the display contents to browsers via Server-Sent Events.
+type mirror struct {
+	sync.Mutex
+
+	// last is the most recently published frame.
+	last *mirrorFrame
+
+	// clients are the channels of all connected browsers.
+	clients map[chan *mirrorFrame]bool
+
+	// tokens protect the mirror like displayd itself; browsers need read
+	// permission.
+	tokens util.Tokens
+}
+
+func newMirror(tokens util.Tokens) *mirror {
+	return &mirror{
+		clients: make(map[chan *mirrorFrame]bool),
+		tokens:  tokens,
+	}
+}
+
+// Publish sends `frame` to all browsers, unless nothing changed.
+// Browsers that do not keep up will miss some frames.
+func (mr *mirror) Publish(frame *mirrorFrame) {
+	mr.Lock()
+	defer mr.Unlock()
+
+	if frame.equal(mr.last) {
+		return
+	}
+
+	mr.last = frame
+	for ch := range mr.clients {
+		select {
+		case ch <- frame:
+		default:
+		}
+	}
+}
+
+func (mr *mirror) subscribe() (chan *mirrorFrame, *mirrorFrame) {
+	mr.Lock()
+	defer mr.Unlock()
+
+	ch := make(chan *mirrorFrame, 16)
+	mr.clients[ch] = true
+	return ch, mr.last
+}
+
+func (mr *mirror) unsubscribe(ch chan *mirrorFrame) {
+	mr.Lock()
+	defer mr.Unlock()
+
+	delete(mr.clients, ch)
+}
+
+func writeEvent(w io.Writer, event string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+	return err
+}
+
+// handleEvents streams a "frame" event on every change of the display and
+// a "switch" event when the active window changed.
+func (mr *mirror) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ch, frame := mr.subscribe()
+	defer mr.unsubscribe(ch)
+
+	active := ""
+	for {
+		if frame != nil {
+			if frame.Active != active {
+				active = frame.Active
+				if err := writeEvent(w, "switch", map[string]string{"active": active}); err != nil {
+					return
+				}
+			}
+
+			if err := writeEvent(w, "frame", frame); err != nil {
+				return
+			}
+
+			flusher.Flush()
+		}
+
+		select {
+		case frame = <-ch:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleFrame replies the current frame as JSON.
+func (mr *mirror) handleFrame(w http.ResponseWriter, r *http.Request) {
+	mr.Lock()
+	frame := mr.last
+	mr.Unlock()
+
+	if frame == nil {
+		frame = &mirrorFrame{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(frame); err != nil {
+		log.Printf("Failed to send frame: %v", err)
+	}
+}
+
+// guard only calls `handler` if the browser may read the display.
+// EventSource can not send headers, so the token is passed as "?token=".
+func (mr *mirror) guard(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		access := util.NewAccess(mr.tokens)
+		if token := r.URL.Query().Get("token"); token != "" {
+			if err := access.Auth(token); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if err := access.Check(r.URL.Path, util.PermRead); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func handleMirrorPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := io.WriteString(w, mirrorPage); err != nil {
+		log.Printf("Failed to send mirror page: %v", err)
+	}
+}
+
+// listenMirror listens on `addr`, which is either "<host>:<port>" or a
+// unix socket like "unix:///run/eulenfunk/mirror.sock".
+func listenMirror(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, util.UnixPrefix) {
+		return util.Listen(addr, 0)
+	}
+
+	host, portSpec, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.Atoi(portSpec)
+	if err != nil {
+		return nil, fmt.Errorf("Bad mirror port `%s`", portSpec)
+	}
+
+	return util.Listen(host, port)
+}
+
+// Serve starts serving the mirror on `addr` until `ctx` is cancelled.
+// All routes need read permission if tokens are set (see guard).
+//
+// Routes:
+//
+//	/        -- A page that draws the display live.
+//	/events  -- Server-Sent Events; "frame" and "switch".
+//	/frame   -- The current frame as JSON.
+func (mr *mirror) Serve(addr string, ctx context.Context) error {
+	lsn, err := listenMirror(addr)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Serving display mirror on %s", lsn.Addr())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", mr.guard(handleMirrorPage))
+	mux.HandleFunc("/events", mr.guard(mr.handleEvents))
+	mux.HandleFunc("/frame", mr.guard(mr.handleFrame))
+
+	go func() {
+		<-ctx.Done()
+		if err := lsn.Close(); err != nil {
+			log.Printf("Failed to close mirror: %v", err)
+		}
+	}()
+
+	go func() {
+		if err := http.Serve(lsn, mux); err != nil && ctx.Err() == nil {
+			log.Printf("Display mirror failed: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+const mirrorPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>eulenfunk display</title>
+  <style>
+    body {
+      background: #202020;
+      color: #c0c0c0;
+      font-family: sans-serif;
+      text-align: center;
+      margin-top: 3em;
+    }
+    #lcd {
+      display: inline-block;
+      margin: 0;
+      padding: 0.6em 0.8em;
+      background: #1740c0;
+      color: #f0f0ff;
+      font-family: monospace;
+      font-size: 2em;
+      line-height: 1.2;
+      border: 0.4em solid #101010;
+      border-radius: 0.2em;
+      white-space: pre;
+      text-align: left;
+    }
+    #status {
+      margin-top: 1em;
+    }
+  </style>
+</head>
+<body>
+  <pre id="lcd"></pre>
+  <div id="status">Connecting...</div>
+  <script>
+    var lcd = document.getElementById("lcd");
+    var statusLine = document.getElementById("status");
+    // Pass the token (if any) on to the event stream:
+    var source = new EventSource("/events" + window.location.search);
+
+    var describe = function(frame) {
+      var text = "Window: " + (frame.active || "(none)");
+      if (frame.popups && frame.popups.length > 0) {
+        text += ", popups: " + frame.popups.join(", ");
+      }
+
+      return text;
+    };
+
+    source.addEventListener("frame", function(ev) {
+      var frame = JSON.parse(ev.data);
+      lcd.textContent = frame.rows.join("\n");
+      lcd.style.opacity = 0.2 + 0.8 * frame.backlight / 100;
+      statusLine.textContent = describe(frame);
+    });
+
+    source.addEventListener("switch", function(ev) {
+      console.log("Switched to window", JSON.parse(ev.data).active);
+    });
+
+    source.onerror = function() {
+      statusLine.textContent = "Disconnected; retrying...";
+    };
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,244 @@
+package display
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/studentkittens/eulenfunk/util"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultOutput is the name of the output described by Config.Width,
+// Config.Height, Config.DriverBinary and Config.Encoding.
+const DefaultOutput = "main"
+
+// OutputConfig describes an additional display driven by displayd.
+type OutputConfig struct {
+	// Name is used to select the output with the `output` command.
+	Name string
+
+	// Width and Height are the size of the display in characters.
+	Width, Height int
+
+	// DriverBinary is the driver program; VirtualDriverName works too.
+	DriverBinary string
+
+	// Driver is used instead of DriverBinary if it is not nil.
+	Driver io.Writer
+
+	// Encoding is the character set of the display; see EncoderNames().
+	Encoding string
+}
+
+// ParseOutput parses an output given as "<name>:<width>x<height>:<driver>"
+// with an optional ":<encoding>" at the end, e.g. "back:16x2:radio-lcd-back".
+func ParseOutput(spec string) (OutputConfig, error) {
+	oc := OutputConfig{}
+
+	split := strings.Split(spec, ":")
+	if len(split) < 3 || len(split) > 4 {
+		return oc, fmt.Errorf("Bad output `%s` (<name>:<w>x<h>:<driver>[:<encoding>] expected)", spec)
+	}
+
+	oc.Name, oc.DriverBinary = split[0], split[2]
+	if oc.Name == "" || oc.DriverBinary == "" {
+		return oc, fmt.Errorf("Output `%s` needs a name and a driver", spec)
+	}
+
+	size := strings.Split(split[1], "x")
+	if len(size) != 2 {
+		return oc, fmt.Errorf("Bad output size `%s` (<w>x<h> expected)", split[1])
+	}
+
+	var err error
+	if oc.Width, err = strconv.Atoi(size[0]); err != nil || oc.Width <= 0 {
+		return oc, fmt.Errorf("Bad output width `%s`", size[0])
+	}
+
+	if oc.Height, err = strconv.Atoi(size[1]); err != nil || oc.Height <= 0 {
+		return oc, fmt.Errorf("Bad output height `%s`", size[1])
+	}
+
+	if len(split) == 4 {
+		oc.Encoding = split[3]
+	}
+
+	return oc, nil
+}
+
+// displayd is a displayd instance with all of its outputs.
+// Every output is driven by its own server with its own windows.
+type displayd struct {
+	sync.Mutex
+
+	// Outputs are the servers of all outputs by name.
+	Outputs map[string]*server
+
+	// Names are the names of the outputs, DefaultOutput first.
+	Names []string
+
+	// Quit is triggered by the quit command.
+	Quit chan bool
+
+	// Tokens protect all outputs; see Config.ControlToken.
+	Tokens util.Tokens
+
+	// Recorder writes all commands to Config.RecordFile (nil if not set).
+	Recorder *recorder
+
+	// lastSessionID is the id given to the last connected client.
+	lastSessionID int
+}
+
+// outputConfig returns a copy of `cfg` with the size and driver of `oc`.
+// Only the DefaultOutput serves the HTTP mirror.
+func outputConfig(cfg *Config, oc OutputConfig) *Config {
+	outCfg := *cfg
+	outCfg.Width, outCfg.Height = oc.Width, oc.Height
+	outCfg.DriverBinary, outCfg.Driver = oc.DriverBinary, oc.Driver
+	outCfg.Encoding, outCfg.NoEncoding = oc.Encoding, false
+	outCfg.Outputs, outCfg.HTTPAddr = nil, ""
+	return &outCfg
+}
+
+func newDisplayd(cfg *Config, parent context.Context) (dd *displayd, err error) {
+	// Outputs that were started before an error must be stopped again:
+	ctx, cancel := context.WithCancel(parent)
+	defer func() {
+		if err != nil {
+			cancel()
+		}
+	}()
+
+	dd = &displayd{
+		Outputs: make(map[string]*server),
+		Quit:    make(chan bool, 1),
+		Tokens:  util.Tokens{Control: cfg.ControlToken, Read: cfg.ReadToken},
+	}
+
+	main, err := newServer(cfg, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dd.Outputs[DefaultOutput] = main
+	dd.Names = append(dd.Names, DefaultOutput)
+
+	for _, oc := range cfg.Outputs {
+		if _, ok := dd.Outputs[oc.Name]; ok {
+			return nil, fmt.Errorf("Output `%s` was given twice", oc.Name)
+		}
+
+		srv, err := newServer(outputConfig(cfg, oc), ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Output `%s`: %v", oc.Name, err)
+		}
+
+		log.Printf("Driving output `%s` (%dx%d)", oc.Name, oc.Width, oc.Height)
+		dd.Outputs[oc.Name] = srv
+		dd.Names = append(dd.Names, oc.Name)
+	}
+
+	if cfg.RecordFile != "" {
+		if dd.Recorder, err = newRecorder(cfg.RecordFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return dd, nil
+}
+
+// Record writes a command of session `id` to the session file, if any.
+func (dd *displayd) Record(id int, cmd string, args []string) {
+	if dd.Recorder != nil {
+		dd.Recorder.Record(id, cmd, args)
+	}
+}
+
+// newSessionID returns a unique id for a new connection.
+func (dd *displayd) newSessionID() int {
+	dd.Lock()
+	defer dd.Unlock()
+
+	dd.lastSessionID++
+	return dd.lastSessionID
+}
+
+// Output returns the server of the output `name`.
+func (dd *displayd) Output(name string) (*server, error) {
+	srv, ok := dd.Outputs[name]
+	if !ok {
+		return nil, fmt.Errorf(
+			"No such output `%s` (choose from: %s)",
+			name, strings.Join(dd.Names, ", "),
+		)
+	}
+
+	return srv, nil
+}
+
+// Input reports user input to all outputs; the user is near all of them.
+func (dd *displayd) Input() {
+	for _, srv := range dd.Outputs {
+		srv.Input()
+	}
+}
+
+// ListOutputs returns one line per output:
+//
+//	<name> <width>x<height> <encoding> <driver> <active window>
+//
+// <driver> is the health of the driver ("up", "down", "up(3)" after 3
+// restarts) or "internal" for drivers running inside displayd.
+func (dd *displayd) ListOutputs() []byte {
+	buf := &bytes.Buffer{}
+	for _, name := range dd.Names {
+		srv := dd.Outputs[name]
+
+		srv.Lock()
+		active := ""
+		if srv.Active != nil {
+			active = srv.Active.Name
+		}
+
+		driver := "internal"
+		if srv.Supervisor != nil {
+			driver = srv.Supervisor.Health().String()
+		}
+
+		fmt.Fprintf(
+			buf, "%s %dx%d %s %s %s\n",
+			name, srv.Config.Width, srv.Config.Height, srv.EncodingName, driver, active,
+		)
+		srv.Unlock()
+	}
+
+	return buf.Bytes()
+}
+
+// ReleaseWindows releases the windows of `owner` on all outputs.
+func (dd *displayd) ReleaseWindows(owner int) {
+	for _, srv := range dd.Outputs {
+		srv.ReleaseWindows(owner)
+	}
+}
+
+func handleOutput(dd *displayd, sess *session, args []string) error {
+	if len(args) < 1 || args[0] == "" {
+		return syntaxError("Usage: output <name>")
+	}
+
+	srv, err := dd.Output(args[0])
+	if err != nil {
+		return rejectedError(err)
+	}
+
+	sess.Output = srv
+	return nil
+}
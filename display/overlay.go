@@ -0,0 +1,146 @@
+package display
+
+import (
+	"fmt"
+	"time"
+)
+
+// overlay is a window that is shown on top of the active window.
+// It covers `Rows` rows starting at `Row`; the rest stays visible.
+type overlay struct {
+	Window *Window
+	Row    int
+	Rows   int
+
+	// Expires is the time when the overlay vanishes again.
+	// If it is zero, the overlay stays until it is dismissed.
+	Expires time.Time
+}
+
+// earliest returns the earlier of `a` and `b`, ignoring zero times.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+
+	return a
+}
+
+// Popup shows the window `name` on top of the active window for `duration`
+// (or until dismissed if `duration` is 0). It covers `rows` rows starting at
+// `row`. Showing an already shown overlay again moves it to the top.
+func (srv *server) Popup(name string, duration time.Duration, row, rows int) error {
+	srv.Lock()
+	defer srv.Unlock()
+
+	if row < 0 || row >= srv.Config.Height {
+		return fmt.Errorf("Bad popup row %d", row)
+	}
+
+	if rows <= 0 || row+rows > srv.Config.Height {
+		rows = srv.Config.Height - row
+	}
+
+	ov := &overlay{
+		Window: srv.lookupWindow(name),
+		Row:    row,
+		Rows:   rows,
+	}
+
+	if duration > 0 {
+		ov.Expires = time.Now().Add(duration)
+	}
+
+	srv.removeOverlay(name)
+	srv.Overlays = append(srv.Overlays, ov)
+	ov.Window.Switch()
+	srv.touch()
+	return nil
+}
+
+// Dismiss removes the overlay showing the window `name`.
+func (srv *server) Dismiss(name string) error {
+	srv.Lock()
+	defer srv.Unlock()
+
+	if !srv.removeOverlay(name) {
+		return fmt.Errorf("Window `%s` is not shown as popup", name)
+	}
+
+	srv.touch()
+	return nil
+}
+
+func (srv *server) removeOverlay(name string) bool {
+	for idx, ov := range srv.Overlays {
+		if ov.Window.Name == name {
+			srv.Overlays = append(srv.Overlays[:idx], srv.Overlays[idx+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// advanceOverlays drops expired overlays and scrolls the remaining ones.
+// It returns the time when it needs to be called next (zero if never).
+func (srv *server) advanceOverlays(now time.Time) time.Time {
+	next := time.Time{}
+	alive := srv.Overlays[:0]
+
+	for _, ov := range srv.Overlays {
+		if !ov.Expires.IsZero() && !now.Before(ov.Expires) {
+			continue
+		}
+
+		_, scrollNext := ov.Window.Advance(now)
+		next = earliest(next, earliest(scrollNext, ov.Expires))
+		alive = append(alive, ov)
+	}
+
+	srv.Overlays = alive
+	return next
+}
+
+// render returns the active window with all overlays drawn on top.
+func (srv *server) render() [][]rune {
+	if srv.Saver.Window != nil {
+		matrix := make([][]rune, srv.Config.Height)
+		copy(matrix, srv.Saver.Window.Render())
+		return blankRows(matrix, srv.Config.Width)
+	}
+
+	return srv.renderWindows()
+}
+
+// blankRows replaces missing rows of `matrix` with blank ones.
+func blankRows(matrix [][]rune, width int) [][]rune {
+	for idx, row := range matrix {
+		if row == nil {
+			matrix[idx] = make([]rune, width)
+		}
+	}
+
+	return matrix
+}
+
+// renderWindows renders the active window and the overlays on top of it.
+func (srv *server) renderWindows() [][]rune {
+	matrix := make([][]rune, srv.Config.Height)
+	if srv.Active != nil {
+		copy(matrix, srv.Active.Render())
+	}
+
+	for _, ov := range srv.Overlays {
+		lines := ov.Window.Render()
+		for idx := 0; idx < ov.Rows; idx++ {
+			matrix[ov.Row+idx] = nil
+			if idx < len(lines) {
+				matrix[ov.Row+idx] = lines[idx]
+			}
+		}
+	}
+
+	// Rows not covered by any window are blank:
+	return blankRows(matrix, srv.Config.Width)
+}
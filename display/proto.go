@@ -0,0 +1,209 @@
+package display
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FramedProtocol is the name of the framed protocol for `proto`.
+	FramedProtocol = "json"
+
+	// FramedVersion is the version of the framed protocol.
+	FramedVersion = 1
+
+	// MaxFrameSize is the maximum size of a single frame.
+	MaxFrameSize = 1 << 20
+)
+
+// writeFramed writes `data` prefixed by its size as 8 byte little endian.
+// This is the framing of the framed protocol and of `render` and `list`.
+func writeFramed(w io.Writer, data []byte) error {
+	frame := make([]byte, 8, 8+len(data))
+	binary.LittleEndian.PutUint64(frame, uint64(len(data)))
+
+	// Write it at once, so frames are not torn apart:
+	_, err := w.Write(append(frame, data...))
+	return err
+}
+
+// readFramed reads a single frame as written by writeFramed.
+func readFramed(r io.Reader) ([]byte, error) {
+	sizeBuf := make([]byte, 8)
+	if _, err := io.ReadFull(r, sizeBuf); err != nil {
+		return nil, err
+	}
+
+	size := binary.LittleEndian.Uint64(sizeBuf)
+	if size > MaxFrameSize {
+		return nil, fmt.Errorf("Frame too big (%d bytes)", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// frameRequest is a single command in the framed protocol.
+// Arguments may be JSON strings or numbers.
+type frameRequest struct {
+	ID   int               `json:"id"`
+	Cmd  string            `json:"cmd"`
+	Args []json.RawMessage `json:"args"`
+}
+
+// textArgs converts the arguments to the form used by the text protocol.
+func (req *frameRequest) textArgs() []string {
+	args := []string{}
+	for _, raw := range req.Args {
+		var arg string
+		if err := json.Unmarshal(raw, &arg); err != nil {
+			// Numbers (and anything else) are taken literally:
+			arg = strings.TrimSpace(string(raw))
+		}
+
+		args = append(args, arg)
+	}
+
+	return args
+}
+
+// frameResponse answers exactly one frameRequest with the same ID.
+type frameResponse struct {
+	ID    int    `json:"id"`
+	OK    bool   `json:"ok"`
+	Code  int    `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	// Data is the output of commands like render and list.
+	Data string `json:"data,omitempty"`
+}
+
+func handleProto(sess *session, args []string) error {
+	if sess.Framed {
+		return &ProtocolError{ErrCodeRejected, "Protocol was already negotiated"}
+	}
+
+	if len(args) < 2 || args[0] != FramedProtocol {
+		return syntaxError("Usage: proto %s <version>", FramedProtocol)
+	}
+
+	version, err := parseInt("version", args[1])
+	if err != nil {
+		return err
+	}
+
+	if version != FramedVersion {
+		return &ProtocolError{
+			ErrCodeRejected,
+			fmt.Sprintf("Unsupported version %d (supported: %d)", version, FramedVersion),
+		}
+	}
+
+	sess.Framed = true
+	return nil
+}
+
+// handleFramed reads requests of the framed protocol until the client
+// closes the connection. Every request is answered, including quit.
+func handleFramed(dd *displayd, sess *session, r io.Reader) {
+	for {
+		data, err := readFramed(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Reading frame failed: %v", err)
+			}
+
+			return
+		}
+
+		keepGoing, resp := true, frameResponse{}
+
+		req := frameRequest{}
+		if err = json.Unmarshal(data, &req); err != nil {
+			err = syntaxError("Bad request: %v", err)
+		} else {
+			keepGoing, _, err = dispatch(dd, sess, req.Cmd, req.textArgs())
+		}
+
+		resp.ID, resp.OK = req.ID, err == nil
+		resp.Data, sess.pending = string(sess.pending), nil
+
+		if err != nil {
+			log.Printf("Failed to execute `%s`: %v", req.Cmd, err)
+			resp.Code, resp.Error = errorCode(err)
+		}
+
+		encoded, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("Failed to encode response: %v", err)
+			return
+		}
+
+		if err := writeFramed(sess.Conn, encoded); err != nil {
+			log.Printf("Failed to write response: %v", err)
+			return
+		}
+
+		if !keepGoing {
+			return
+		}
+	}
+}
+
+// frameArgs converts arguments of a command for a frameRequest.
+// Integers are sent as JSON numbers, everything else as string.
+func frameArgs(args []interface{}) ([]json.RawMessage, error) {
+	raws := []json.RawMessage{}
+	for _, arg := range args {
+		if n, ok := arg.(int); ok {
+			raws = append(raws, json.RawMessage(strconv.Itoa(n)))
+			continue
+		}
+
+		raw, err := json.Marshal(fmt.Sprint(arg))
+		if err != nil {
+			return nil, err
+		}
+
+		raws = append(raws, raw)
+	}
+
+	return raws, nil
+}
+
+// commandLine formats a command for the text protocol.
+func commandLine(cmd string, args []interface{}) string {
+	parts := []string{cmd}
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprint(arg))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// parseFrameResponse decodes `data` and converts a failure to an error.
+func parseFrameResponse(data []byte, id int) (*frameResponse, error) {
+	resp := &frameResponse{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, fmt.Errorf("Bad response from displayd: %v", err)
+	}
+
+	if resp.ID != id {
+		return nil, fmt.Errorf("Response for request %d, expected %d", resp.ID, id)
+	}
+
+	if !resp.OK {
+		return nil, &ProtocolError{Code: resp.Code, Message: resp.Error}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,153 @@
+package display
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// newTestDisplayd is like newTestServer, but returns the whole displayd,
+// so connections can be handled like in Run.
+func newTestDisplayd(t *testing.T, recordFile string) (*displayd, *VirtualLCD) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	vl := NewVirtualLCD(20, 2)
+	dd, err := newDisplayd(&Config{
+		Width:      20,
+		Height:     2,
+		Driver:     vl,
+		RecordFile: recordFile,
+	}, ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to create displayd: %v", err)
+	}
+
+	return dd, vl
+}
+
+// connect handles one end of a pipe with handleAll and returns the other.
+// `done` is closed once handleAll returned.
+func connect(dd *displayd) (conn net.Conn, done chan struct{}) {
+	client, server := net.Pipe()
+	done = make(chan struct{})
+
+	go func() {
+		handleAll(dd, server)
+		close(done)
+	}()
+
+	return client, done
+}
+
+// request sends a single framed request and returns the decoded response.
+func request(t *testing.T, conn net.Conn, reader *bufio.Reader, id int, cmd string, args ...interface{}) (*frameResponse, error) {
+	raws, err := frameArgs(args)
+	if err != nil {
+		t.Fatalf("Failed to convert arguments: %v", err)
+	}
+
+	data, err := json.Marshal(frameRequest{ID: id, Cmd: cmd, Args: raws})
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+
+	if err := writeFramed(conn, data); err != nil {
+		t.Fatalf("Failed to send `%s`: %v", cmd, err)
+	}
+
+	data, err = readFramed(reader)
+	if err != nil {
+		t.Fatalf("Failed to read response to `%s`: %v", cmd, err)
+	}
+
+	return parseFrameResponse(data, id)
+}
+
+func TestFramedSession(t *testing.T) {
+	dd, vl := newTestDisplayd(t, "")
+	conn, done := connect(dd)
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	if _, err := conn.Write([]byte("proto json 1\n")); err != nil {
+		t.Fatalf("Failed to negotiate: %v", err)
+	}
+
+	if reply, err := reader.ReadString('\n'); err != nil || reply != "OK\n" {
+		t.Fatalf("proto was answered with `%s` (%v)", reply, err)
+	}
+
+	tcs := []struct {
+		cmd  string
+		args []interface{}
+		code int
+	}{
+		{"line", []interface{}{"w", 0, "Hello framed"}, 0},
+		{"line", []interface{}{"w", 1, "  spaces  kept  "}, 0},
+		{"switch", []interface{}{"w"}, 0},
+		{"line", []interface{}{"w", "first", "x"}, ErrCodeSyntax},
+		{"line", []interface{}{"w"}, ErrCodeSyntax},
+		{"blink", []interface{}{"w"}, ErrCodeUnknown},
+		{"proto", []interface{}{"json", 1}, ErrCodeRejected},
+	}
+
+	for idx, tc := range tcs {
+		_, err := request(t, conn, reader, idx+1, tc.cmd, tc.args...)
+		if tc.code == 0 {
+			if err != nil {
+				t.Errorf("`%s %v` failed: %v", tc.cmd, tc.args, err)
+			}
+
+			continue
+		}
+
+		protoErr, ok := err.(*ProtocolError)
+		if !ok || protoErr.Code != tc.code {
+			t.Errorf("`%s %v` gave %v (want code %d)", tc.cmd, tc.args, err, tc.code)
+		}
+	}
+
+	// A broken request is answered, but does not end the session:
+	if err := writeFramed(conn, []byte("{nope")); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	data, err := readFramed(reader)
+	if err != nil {
+		t.Fatalf("Bad request was not answered: %v", err)
+	}
+
+	if _, err := parseFrameResponse(data, 0); err == nil {
+		t.Errorf("Bad request was accepted: %s", data)
+	}
+
+	resp, err := request(t, conn, reader, 100, "render")
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+
+	if !strings.HasPrefix(resp.Data, "Hello framed") {
+		t.Errorf("render returned `%s`", resp.Data)
+	}
+
+	// Windows are released when the connection ends, so look before:
+	dd.Outputs[DefaultOutput].update(time.Now())
+	checkGolden(t, "framed", vl.Frames())
+
+	if _, err := request(t, conn, reader, 101, "close"); err != nil {
+		t.Errorf("close failed: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Connection was not closed after close")
+	}
+}
@@ -0,0 +1,171 @@
+package display
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/studentkittens/eulenfunk/util"
+
+	"golang.org/x/net/context"
+)
+
+// recordEntry is a single line of a session file.
+type recordEntry struct {
+	// Time is when displayd received the command.
+	Time time.Time `json:"time"`
+
+	// Conn is the id of the connection that sent the command.
+	Conn int `json:"conn"`
+
+	// Cmd and Args are the command as passed to dispatch.
+	Cmd  string   `json:"cmd"`
+	Args []string `json:"args,omitempty"`
+}
+
+// recorder writes every command to a session file, one JSON object per line.
+type recorder struct {
+	sync.Mutex
+
+	fd  *os.File
+	enc *json.Encoder
+}
+
+func newRecorder(path string) (*recorder, error) {
+	fd, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create session file: %v", err)
+	}
+
+	log.Printf("Recording session to `%s`", path)
+	return &recorder{fd: fd, enc: json.NewEncoder(fd)}, nil
+}
+
+// Record appends `cmd` of connection `conn` to the session file.
+func (rec *recorder) Record(conn int, cmd string, args []string) {
+	rec.Lock()
+	defer rec.Unlock()
+
+	entry := recordEntry{Time: time.Now(), Conn: conn, Cmd: cmd, Args: args}
+	if err := rec.enc.Encode(entry); err != nil {
+		log.Printf("Failed to record `%s`: %v", cmd, err)
+	}
+}
+
+func (rec *recorder) Close() error {
+	rec.Lock()
+	defer rec.Unlock()
+
+	return rec.fd.Close()
+}
+
+// replaySkipped are commands that are recorded, but not replayed.
+// They either only ask for output or would disturb the replaying displayd.
+var replaySkipped = map[string]bool{
+	"proto":   true,
+	"ack":     true,
+	"render":  true,
+	"list":    true,
+	"outputs": true,
+	"quit":    true,
+}
+
+func readSession(path string) ([]recordEntry, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer util.Closer(fd)
+
+	entries := []recordEntry{}
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, 4096), MaxFrameSize)
+
+	for lineno := 1; scanner.Scan(); lineno++ {
+		entry := recordEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: Bad session entry: %v", path, lineno, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// ReplayClient plays the session file at `path` (see Config.RecordFile) back
+// into the displayd given by `cfg`. Every recorded connection gets its own
+// connection again, so window ownership works like in the original session.
+// The pauses between commands are divided by `speed`; zero means no pauses.
+func ReplayClient(cfg *Config, ctx context.Context, path string, speed float64) error {
+	entries, err := readSession(path)
+	if err != nil {
+		return err
+	}
+
+	replayCfg := *cfg
+	replayCfg.Framed = true
+
+	conns := make(map[int]*LineWriter)
+	defer func() {
+		for _, lw := range conns {
+			util.Closer(lw)
+		}
+	}()
+
+	log.Printf("Replaying %d commands from `%s`", len(entries), path)
+
+	for idx, entry := range entries {
+		if idx > 0 && speed > 0 {
+			pause := entry.Time.Sub(entries[idx-1].Time)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(float64(pause) / speed)):
+			}
+		}
+
+		if cancelled(ctx) {
+			return ctx.Err()
+		}
+
+		if replaySkipped[entry.Cmd] {
+			continue
+		}
+
+		lw, ok := conns[entry.Conn]
+		if entry.Cmd == "close" {
+			if ok {
+				util.Closer(lw)
+				delete(conns, entry.Conn)
+			}
+
+			continue
+		}
+
+		if !ok {
+			if lw, err = Connect(&replayCfg, ctx); err != nil {
+				return err
+			}
+
+			conns[entry.Conn] = lw
+		}
+
+		args := []interface{}{}
+		for _, arg := range entry.Args {
+			args = append(args, arg)
+		}
+
+		if err := lw.command(entry.Cmd, args...); err != nil {
+			log.Printf("Replaying `%s` of connection %d failed: %v", entry.Cmd, entry.Conn, err)
+		}
+	}
+
+	return nil
+}
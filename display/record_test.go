@@ -0,0 +1,116 @@
+package display
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/studentkittens/eulenfunk/util"
+	"golang.org/x/net/context"
+)
+
+// recordSession sends `lines` on a new connection to `dd` and closes it.
+// Replies are expected for all lines, so ack should be the first one.
+func recordSession(t *testing.T, dd *displayd, lines ...string) {
+	conn, done := connect(dd)
+	reader := bufio.NewReader(conn)
+
+	for _, line := range lines {
+		if _, err := conn.Write([]byte(line + "\n")); err != nil {
+			t.Fatalf("Failed to send `%s`: %v", line, err)
+		}
+
+		if _, err := reader.ReadString('\n'); err != nil {
+			t.Fatalf("Failed to read reply to `%s`: %v", line, err)
+		}
+	}
+
+	conn.Close()
+	<-done
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "displayd-record")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session.jsonl")
+	recDd, recVl := newTestDisplayd(t, path)
+
+	recordSession(t, recDd,
+		"ack",
+		"line w 0 Recorded",
+		"blink w",
+		"switch w",
+	)
+
+	recordSession(t, recDd,
+		"ack",
+		"line w 1 by another conn",
+	)
+
+	if err := recDd.Recorder.Close(); err != nil {
+		t.Fatalf("Failed to close session file: %v", err)
+	}
+
+	entries, err := readSession(path)
+	if err != nil {
+		t.Fatalf("Failed to read session: %v", err)
+	}
+
+	// Failed commands are not recorded:
+	cmds := []string{}
+	for _, entry := range entries {
+		cmds = append(cmds, entry.Cmd)
+	}
+
+	want := []string{"ack", "line", "switch", "close", "ack", "line", "close"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("Recorded %v (want %v)", cmds, want)
+	}
+
+	recDd.Outputs[DefaultOutput].update(time.Now())
+	checkGolden(t, "replay", recVl.Frames())
+
+	// Replay into a second displayd, over a real socket like the CLI does:
+	host := "unix://" + filepath.Join(dir, "displayd.sock")
+	lsn, err := util.Listen(host, 0)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	defer util.Closer(lsn)
+
+	playDd, playVl := newTestDisplayd(t, "")
+	go func() {
+		for {
+			conn, err := lsn.Accept()
+			if err != nil {
+				return
+			}
+
+			go handleAll(playDd, conn)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ReplayClient(&Config{Host: host}, ctx, path, 0); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	// Every replayed command was answered, so it is on the display now:
+	playDd.Outputs[DefaultOutput].update(time.Now())
+	golden := filepath.Join("testdata", "replay.golden")
+	if err := CompareGolden(golden, false, playVl.Frames()...); err != nil {
+		t.Errorf("Replay differs from the recording: %v", err)
+	}
+}
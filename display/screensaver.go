@@ -0,0 +1,193 @@
+package display
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Possible values of Config.Screensaver:
+const (
+	// ScreensaverClock shows the time and the date in the middle (default).
+	ScreensaverClock = "clock"
+
+	// ScreensaverDrift shows the time at a different place every few
+	// seconds, so no part of the display is lit all the time.
+	ScreensaverDrift = "drift"
+
+	// ScreensaverBlank shows nothing and turns the backlight off.
+	ScreensaverBlank = "blank"
+)
+
+// ScreensaverWindow is the name under which the screensaver is shown.
+// It is not a normal window; clients can not write to it.
+const ScreensaverWindow = "screensaver"
+
+// driftInterval is the time after which ScreensaverDrift moves the time.
+const driftInterval = 10 * time.Second
+
+// ScreensaverModes returns all possible values of Config.Screensaver.
+func ScreensaverModes() []string {
+	return []string{ScreensaverClock, ScreensaverDrift, ScreensaverBlank}
+}
+
+func checkScreensaverMode(mode string) error {
+	for _, known := range ScreensaverModes() {
+		if mode == known {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(
+		"No such screensaver `%s` (choose from: %s)",
+		mode, strings.Join(ScreensaverModes(), ", "),
+	)
+}
+
+// screensaver tracks the inactivity of the display.
+type screensaver struct {
+	// Mode is one of the Screensaver* constants.
+	Mode string
+
+	// Window is shown instead of all other windows; nil while not idle.
+	Window *Window
+
+	// rows are the contents of the display without the screensaver.
+	rows [][]rune
+
+	// lastChange is the time when rows changed last
+	// or a client sent a command.
+	lastChange time.Time
+
+	// nextDrift is the time when ScreensaverDrift moves the time next.
+	nextDrift time.Time
+}
+
+func equalRows(a, b [][]rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for idx := range a {
+		if string(a[idx]) != string(b[idx]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newScreensaverWindow creates the window for the current mode.
+func (srv *server) newScreensaverWindow(now time.Time) *Window {
+	width, height := srv.Config.Width, srv.Config.Height
+
+	win := NewWindow(ScreensaverWindow, width, height, srv.Encoder)
+	win.Glyphs = srv.charset(ScreensaverWindow)
+
+	if srv.Saver.Mode != ScreensaverClock {
+		return win
+	}
+
+	timeRow := (height - 1) / 2
+	rows := map[int]string{timeRow: "15:04:05", timeRow + 1: "2 January 2006"}
+
+	for pos, layout := range rows {
+		if pos >= height {
+			continue
+		}
+
+		if err := win.SetAttrs(pos, [][2]string{{"align", AlignCenter}}); err != nil {
+			log.Printf("Failed to align screensaver: %v", err)
+		}
+
+		if err := win.SetWidget(pos, &clockWidget{Layout: layout}, now); err != nil {
+			log.Printf("Failed to set screensaver clock: %v", err)
+		}
+	}
+
+	return win
+}
+
+// drift moves the time of ScreensaverDrift to a random place.
+func (srv *server) drift(now time.Time) {
+	win, layout := srv.Saver.Window, "15:04"
+
+	for pos := range win.Lines {
+		if err := win.SetLine(pos, ""); err != nil {
+			log.Printf("Failed to clear screensaver: %v", err)
+		}
+	}
+
+	room := win.Width - len(layout) + 1
+	if room < 1 {
+		room = 1
+	}
+
+	pos := rand.Intn(win.Height)
+	indent := strings.Repeat(" ", rand.Intn(room))
+
+	if err := win.SetWidget(pos, &clockWidget{Layout: indent + layout}, now); err != nil {
+		log.Printf("Failed to move screensaver clock: %v", err)
+	}
+
+	srv.Saver.nextDrift = now.Add(driftInterval)
+}
+
+// Wake hides the screensaver (if shown) and restarts the idle timeout.
+func (srv *server) Wake() {
+	srv.Lock()
+	defer srv.Unlock()
+
+	srv.Saver.lastChange = time.Now()
+	srv.touch()
+}
+
+// updateScreensaver shows the screensaver when neither the display changed
+// nor a command or input was reported for Config.IdleAfter, and hides it
+// again when one of those happens. It returns when it needs to be called next.
+func (srv *server) updateScreensaver(now time.Time) time.Time {
+	if srv.Config.IdleAfter <= 0 {
+		return time.Time{}
+	}
+
+	saver := &srv.Saver
+
+	if rows := srv.renderWindows(); !equalRows(rows, saver.rows) {
+		saver.rows = rows
+		saver.lastChange = now
+	}
+
+	lastActivity := saver.lastChange
+	if srv.Light.LastInput.After(lastActivity) {
+		lastActivity = srv.Light.LastInput
+	}
+
+	if idleAt := lastActivity.Add(srv.Config.IdleAfter); now.Before(idleAt) {
+		if saver.Window != nil {
+			log.Printf("Leaving screensaver")
+			saver.Window = nil
+		}
+
+		return idleAt
+	}
+
+	if saver.Window == nil {
+		log.Printf("Idle for %s; starting screensaver `%s`", srv.Config.IdleAfter, saver.Mode)
+		saver.Window = srv.newScreensaverWindow(now)
+		saver.nextDrift = now
+	}
+
+	next := time.Time{}
+	if saver.Mode == ScreensaverDrift {
+		if !now.Before(saver.nextDrift) {
+			srv.drift(now)
+		}
+
+		next = saver.nextDrift
+	}
+
+	_, windowNext := saver.Window.Advance(now)
+	return earliest(next, windowNext)
+}
@@ -2,15 +2,16 @@ package display
 
 import (
 	"bufio"
-	"encoding/binary"
+	"bytes"
 	"fmt"
 	"io"
 	"log"
-	"net"
-	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/studentkittens/eulenfunk/util"
 
@@ -32,10 +33,78 @@ type Config struct {
 	Height int
 
 	// DriverBinary is the name of the driver to write the output too.
+	// If it is VirtualDriverName, an in-process VirtualLCD is used.
 	DriverBinary string
 
-	// NoEncoding disables the special LCD encoding
+	// Driver is used instead of DriverBinary if it is not nil.
+	// This is mainly useful for passing a VirtualLCD in tests.
+	Driver io.Writer
+
+	// Encoding is the name of the character set of the display.
+	// See EncoderNames() for possible values; defaults to DefaultEncoding.
+	Encoding string
+
+	// NoEncoding disables the special LCD encoding.
+	// It is the same as setting Encoding to "utf8".
 	NoEncoding bool
+
+	// Acknowledged makes clients wait for an OK or ERR reply after each
+	// command, so rejected commands can be reported to the caller.
+	Acknowledged bool
+
+	// Framed makes clients use the framed protocol instead of the text
+	// protocol. Every command is acknowledged then.
+	Framed bool
+
+	// Output is the output clients write to; DefaultOutput if empty.
+	Output string
+
+	// CollectWindows makes displayd destroy the windows of a client
+	// when its connection is closed. Otherwise they are kept ownerless.
+	CollectWindows bool
+
+	// HTTPAddr is the address (e.g. ":8080") where a live mirror of the
+	// display is served to browsers. If empty, no mirror is served.
+	HTTPAddr string
+
+	// Backlight and Contrast are the initial levels (1-100) of the display.
+	// Zero means DefaultBacklight and DefaultContrast.
+	Backlight int
+	Contrast  int
+
+	// DimAfter dims the backlight to DimLevel when no input was reported
+	// for that long. Zero disables dimming.
+	DimAfter time.Duration
+	DimLevel int
+
+	// Night is a daily time range like "22:00-07:00" in which the
+	// backlight is at most NightLevel. If empty, there is no night.
+	Night      string
+	NightLevel int
+
+	// IdleAfter shows the Screensaver when the display did not change and
+	// no input was reported for that long. Zero disables the screensaver.
+	IdleAfter time.Duration
+
+	// Screensaver is one of ScreensaverModes(); defaults to ScreensaverClock.
+	Screensaver string
+
+	// Outputs are further displays driven next to the DefaultOutput.
+	// Each has its own windows; clients select one with `output`.
+	Outputs []OutputConfig
+
+	// Token is sent by clients to authenticate (see ControlToken).
+	Token string
+
+	// ControlToken and ReadToken protect displayd (see util.Tokens).
+	// Without ControlToken every client may do everything.
+	ControlToken string
+	ReadToken    string
+
+	// RecordFile is a session file every successful command is written
+	// to with its time and connection, so it can be replayed with ReplayClient.
+	// If empty, nothing is recorded.
+	RecordFile string
 }
 
 ///////////////////////////
@@ -47,74 +116,360 @@ type server struct {
 	Config     *Config
 	Windows    map[string]*Window
 	Active     *Window
-	Quit       chan bool
 	DriverPipe io.Writer
+
+	// Overlays are shown on top of Active; the last one is the topmost.
+	Overlays []*overlay
+
+	// Encoder converts text to the character set of the display.
+	Encoder Encoder
+
+	// EncodingName is the name of Encoder.
+	EncodingName string
+
+	// Glyphs are the contents of the programmable LCD slots.
+	Glyphs [NGlyphSlots]glyphSlot
+
+	// Screen is what the driver currently shows (nil before the first render)
+	Screen [][]rune
+
+	// Mirror streams the display to browsers (nil if disabled).
+	Mirror *mirror
+
+	// Light is the state of the backlight and the contrast.
+	Light lighting
+
+	// Supervisor restarts the driver binary; nil for internal drivers.
+	Supervisor *util.Supervisor
+
+	// Saver is shown instead of all windows when the display is idle.
+	Saver screensaver
+
+	// wakeup tells the render loop that something might have changed.
+	wakeup chan struct{}
 }
 
-func (srv *server) renderToDriver() {
-	srv.Lock()
-	defer srv.Unlock()
+// renderRows converts the visible windows to Height rows of Width runes each.
+// Zero means "end of text" in the driver protocol, so blank areas are spaces.
+func (srv *server) renderRows() [][]rune {
+	matrix := srv.render()
+	rows := make([][]rune, srv.Config.Height)
 
-	if srv.Active == nil {
+	for idx := range rows {
+		rows[idx] = []rune(strings.Repeat(" ", srv.Config.Width))
+		if idx >= len(matrix) {
+			continue
+		}
+
+		for off, rn := range matrix[idx] {
+			if off < srv.Config.Width && rn != 0 {
+				rows[idx][off] = rn
+			}
+		}
+	}
+
+	return rows
+}
+
+// diffRange returns the first and last index where `a` and `b` differ.
+// If they are equal, -1 is returned for both.
+func diffRange(a, b []rune) (int, int) {
+	lo, hi := -1, -1
+	for idx := range b {
+		if idx < len(a) && a[idx] == b[idx] {
+			continue
+		}
+
+		if lo < 0 {
+			lo = idx
+		}
+
+		hi = idx
+	}
+
+	return lo, hi
+}
+
+// renderToDriver sends all parts of the active window that changed since the
+// last call to the driver. Unchanged rows are not sent at all; partially
+// changed rows are sent in the "<line>,<offset> <text>" form.
+// It has to be called with the server lock held.
+func (srv *server) renderToDriver() {
+	// Nothing to show yet:
+	if srv.Active == nil && len(srv.Overlays) == 0 && srv.Screen == nil {
 		return
 	}
 
-	for idx, line := range srv.Active.Render() {
-		if _, err := srv.DriverPipe.Write([]byte(fmt.Sprintf("%d ", idx))); err != nil {
+	rows := srv.renderRows()
+	written := false
+
+	for idx, row := range rows {
+		header, data := fmt.Sprintf("%d ", idx), row
+
+		if srv.Screen != nil {
+			lo, hi := diffRange(srv.Screen[idx], row)
+			if lo < 0 {
+				// Nothing changed in this row:
+				continue
+			}
+
+			// Without offset the driver clears the rest of the row,
+			// so only use the short form when we send a full row:
+			if lo > 0 {
+				header = fmt.Sprintf("%d,%d ", idx, lo)
+				data = row[lo : hi+1]
+			}
+		}
+
+		line := append([]byte(header), srv.Encoder.Output(data)...)
+		if _, err := srv.DriverPipe.Write(append(line, '\n')); err != nil {
 			log.Printf("Failed to write to driver: %v", err)
 		}
 
-		// Convert []rune to []byte manually:
-		binaryLine := make([]byte, len(line))
-		for off, rn := range line {
-			binaryLine[off] = byte(rn)
+		written = true
+	}
+
+	srv.Screen = rows
+
+	if !written {
+		return
+	}
+
+	// Tell frame-aware drivers (like VirtualLCD) that we're done:
+	if flusher, ok := srv.DriverPipe.(driverFlusher); ok {
+		if err := flusher.Flush(); err != nil {
+			log.Printf("Failed to flush driver: %v", err)
+		}
+	}
+}
+
+// driverFlusher is implemented by drivers that want to know
+// when a complete frame was written.
+type driverFlusher interface {
+	Flush() error
+}
+
+func openDriver(cfg *Config) (io.Writer, error) {
+	if cfg.Driver != nil {
+		return cfg.Driver, nil
+	}
+
+	if cfg.DriverBinary == VirtualDriverName {
+		return NewVirtualLCD(cfg.Width, cfg.Height), nil
+	}
+
+	supervisor := util.NewSupervisor(cfg.DriverBinary)
+	if err := supervisor.Start(); err != nil {
+		return nil, err
+	}
+
+	return supervisor, nil
+}
+
+// replayDriver brings a restarted driver back to the state before the crash:
+// it gets the loaded glyphs, the current levels and a full frame again.
+func (srv *server) replayDriver() {
+	srv.Lock()
+	defer srv.Unlock()
+
+	for slot, gs := range srv.Glyphs {
+		if gs.Glyph != defaultGlyphs[slot] {
+			srv.sendGlyph(slot)
 		}
+	}
 
-		binaryLine = append(binaryLine, '\n')
+	srv.Screen = nil
+	srv.Light.sentBacklight, srv.Light.sentContrast = -1, -1
+	srv.touch()
+}
 
-		if _, err := srv.DriverPipe.Write(binaryLine); err != nil {
-			log.Printf("Failed to write to driver: %v", err)
+// encodingName returns the name of the encoding `cfg` asks for.
+func (cfg *Config) encodingName() string {
+	switch {
+	case cfg.NoEncoding:
+		return "utf8"
+	case cfg.Encoding == "":
+		return DefaultEncoding
+	}
+
+	return cfg.Encoding
+}
+
+// Check returns an error if a value of `cfg` would make newServer fail.
+// Empty values mean the defaults.
+func (cfg *Config) Check() error {
+	if _, err := LookupEncoder(cfg.encodingName()); err != nil {
+		return err
+	}
+
+	if cfg.Screensaver != "" {
+		if err := checkScreensaverMode(cfg.Screensaver); err != nil {
+			return err
 		}
 	}
+
+	_, err := util.ParseNightSchedule(cfg.Night)
+	return err
 }
 
 // newServer returns a displayd instance based on `cfg` and the cancel context `ctx`.
 func newServer(cfg *Config, ctx context.Context) (*server, error) {
-	cmd := exec.Command(cfg.DriverBinary)
-	stdinPipe, err := cmd.StdinPipe()
+	if err := cfg.Check(); err != nil {
+		return nil, err
+	}
+
+	encoding := cfg.encodingName()
+	encoder, err := LookupEncoder(encoding)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := cmd.Start(); err != nil {
+	driverPipe, err := openDriver(cfg)
+	if err != nil {
 		return nil, err
 	}
 
 	srv := &server{
-		Config:     cfg,
-		Windows:    make(map[string]*Window),
-		Quit:       make(chan bool, 1),
-		DriverPipe: stdinPipe,
+		Config:       cfg,
+		Windows:      make(map[string]*Window),
+		DriverPipe:   driverPipe,
+		Encoder:      encoder,
+		EncodingName: encoding,
+		wakeup:       make(chan struct{}, 1),
 	}
 
-	go func() {
-		// Update the screen with 2Hz
-		ticker := time.NewTicker(500 * time.Millisecond)
+	srv.initGlyphs()
+
+	if vl, ok := driverPipe.(*VirtualLCD); ok {
+		// Flush is called during rendering, with the server lock held:
+		vl.attach(encoder, srv.decode)
+	}
 
-		for {
+	if supervisor, ok := driverPipe.(*util.Supervisor); ok {
+		srv.Supervisor = supervisor
+		supervisor.Restarted = srv.replayDriver
+
+		go func() {
+			<-ctx.Done()
+			supervisor.Close()
+		}()
+	}
+
+	srv.Saver.Mode = cfg.Screensaver
+	if srv.Saver.Mode == "" {
+		srv.Saver.Mode = ScreensaverClock
+	}
+
+	if err := srv.initLighting(); err != nil {
+		return nil, err
+	}
+
+	if cfg.HTTPAddr != "" {
+		srv.Mirror = newMirror(util.Tokens{Control: cfg.ControlToken, Read: cfg.ReadToken})
+		if err := srv.Mirror.Serve(cfg.HTTPAddr, ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	go srv.renderLoop(ctx)
+	return srv, nil
+}
+
+// touch tells the render loop to check for changes as soon as possible.
+func (srv *server) touch() {
+	select {
+	case srv.wakeup <- struct{}{}:
+	default:
+		// A wakeup is already pending.
+	}
+}
+
+// update advances scrolling lines and sends changes to the driver.
+// It returns when it needs to be called next (zero if not needed).
+func (srv *server) update(now time.Time) time.Time {
+	srv.Lock()
+	defer srv.Unlock()
+
+	next := srv.advanceOverlays(now)
+	if srv.Active != nil {
+		_, activeNext := srv.Active.Advance(now)
+		next = earliest(next, activeNext)
+	}
+
+	// The screensaver decides about the backlight too:
+	next = earliest(next, srv.updateScreensaver(now))
+	next = earliest(next, srv.updateLighting(now))
+
+	srv.renderToDriver()
+
+	if srv.Mirror != nil && srv.Screen != nil {
+		srv.Mirror.Publish(srv.mirrorFrame())
+	}
+
+	return next
+}
+
+// mirrorFrame returns what the driver currently shows in readable form.
+func (srv *server) mirrorFrame() *mirrorFrame {
+	frame := &mirrorFrame{
+		Popups:    []string{},
+		Backlight: srv.Light.sentBacklight,
+	}
+
+	switch {
+	case srv.Saver.Window != nil:
+		frame.Active = ScreensaverWindow
+	case srv.Active != nil:
+		frame.Active = srv.Active.Name
+		fallthrough
+	default:
+		for _, ov := range srv.Overlays {
+			frame.Popups = append(frame.Popups, ov.Window.Name)
+		}
+	}
+
+	for _, row := range srv.Screen {
+		frame.Rows = append(frame.Rows, srv.decode(row))
+	}
+
+	return frame
+}
+
+// renderLoop updates the screen when something changed or
+// when a scrolling line needs to be shifted.
+func (srv *server) renderLoop(ctx context.Context) {
+	timer := time.NewTimer(0)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-srv.wakeup:
+		case <-timer.C:
+		}
+
+		// select picks randomly if a wakeup is pending too:
+		if ctx.Err() != nil {
+			return
+		}
+
+		next := srv.update(time.Now())
+
+		if !timer.Stop() {
 			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				srv.renderToDriver()
+			case <-timer.C:
+			default:
 			}
 		}
-	}()
 
-	return srv, nil
+		if !next.IsZero() {
+			timer.Reset(next.Sub(time.Now()))
+		}
+	}
 }
 
-func (srv *server) createOrLookupWindow(name string) *Window {
+// lookupWindow returns the window `name`, creating it if needed.
+func (srv *server) lookupWindow(name string) *Window {
 	win, ok := srv.Windows[name]
 
 	if !ok {
@@ -122,11 +477,20 @@ func (srv *server) createOrLookupWindow(name string) *Window {
 		win = NewWindow(
 			name,
 			srv.Config.Width, srv.Config.Height,
-			!srv.Config.NoEncoding,
+			srv.Encoder,
 		)
+		win.Glyphs = srv.charset(name)
 		srv.Windows[name] = win
 	}
 
+	return win
+}
+
+// createOrLookupWindow is like lookupWindow, but also makes
+// the window active if there is no active window yet.
+func (srv *server) createOrLookupWindow(name string) *Window {
+	win := srv.lookupWindow(name)
+
 	if srv.Active == nil {
 		srv.Active = win
 	}
@@ -148,6 +512,7 @@ func (srv *server) Switch(name string) {
 
 	win.Switch()
 	srv.Active = win
+	srv.touch()
 	return
 }
 
@@ -155,6 +520,7 @@ func (srv *server) SetLine(name string, pos int, text string) error {
 	srv.Lock()
 	defer srv.Unlock()
 
+	srv.touch()
 	return srv.createOrLookupWindow(name).SetLine(pos, text)
 }
 
@@ -162,41 +528,154 @@ func (srv *server) SetScrollDelay(name string, pos int, delay time.Duration) err
 	srv.Lock()
 	defer srv.Unlock()
 
+	srv.touch()
 	return srv.createOrLookupWindow(name).SetScrollDelay(pos, delay)
 }
 
+func (srv *server) SetAttrs(name string, pos int, attrs [][2]string) error {
+	srv.Lock()
+	defer srv.Unlock()
+
+	srv.touch()
+	return srv.createOrLookupWindow(name).SetAttrs(pos, attrs)
+}
+
+func (srv *server) SetWidget(name string, pos int, widget Widget) error {
+	srv.Lock()
+	defer srv.Unlock()
+
+	srv.touch()
+	return srv.createOrLookupWindow(name).SetWidget(pos, widget, time.Now())
+}
+
+func (srv *server) Begin(window string) {
+	srv.Lock()
+	defer srv.Unlock()
+
+	srv.createOrLookupWindow(window).Begin()
+}
+
+func (srv *server) Commit(window string) error {
+	srv.Lock()
+	defer srv.Unlock()
+
+	srv.touch()
+	return srv.createOrLookupWindow(window).Commit()
+}
+
 func (srv *server) Move(window string, n int) {
 	srv.Lock()
 	defer srv.Unlock()
 
 	srv.createOrLookupWindow(window).Move(n)
+	srv.touch()
 }
 
 func (srv *server) Truncate(window string, n int) {
 	srv.Lock()
+	defer srv.Unlock()
+
 	win := srv.createOrLookupWindow(window)
-	nlines := win.Truncate(n)
-	srv.Unlock()
+	win.NLines = win.Truncate(n)
+	srv.touch()
+}
 
-	srv.renderToDriver()
+// Adopt creates the window `name` if needed. If the window has no owner
+// yet, the connection with the id `owner` becomes its owner.
+func (srv *server) Adopt(name string, owner int) {
+	srv.Lock()
+	defer srv.Unlock()
+
+	if win := srv.lookupWindow(name); win.Owner == 0 {
+		win.Owner = owner
+	}
+}
 
+// Destroy removes the window `name` completely.
+// If it was the active window, the display is blank afterwards.
+func (srv *server) Destroy(name string) error {
 	srv.Lock()
-	win.NLines = nlines
-	srv.Unlock()
+	defer srv.Unlock()
 
-	srv.renderToDriver()
+	return srv.destroy(name)
+}
+
+func (srv *server) destroy(name string) error {
+	win, ok := srv.Windows[name]
+	if !ok {
+		return fmt.Errorf("No such window `%s`", name)
+	}
+
+	log.Printf("Destroying window `%s`", name)
+	delete(srv.Windows, name)
+	srv.removeOverlay(name)
+	srv.releaseGlyphs(name)
+
+	if srv.Active == win {
+		srv.Active = nil
+	}
+
+	srv.touch()
+	return nil
+}
+
+// ReleaseWindows is called when the connection with the id `owner` is
+// closed. Its windows are destroyed if Config.CollectWindows is set,
+// otherwise they just lose their owner.
+func (srv *server) ReleaseWindows(owner int) {
+	srv.Lock()
+	defer srv.Unlock()
+
+	for name, win := range srv.Windows {
+		if win.Owner != owner {
+			continue
+		}
+
+		win.Owner = 0
+		if srv.Config.CollectWindows {
+			if err := srv.destroy(name); err != nil {
+				log.Printf("Failed to collect window: %v", err)
+			}
+		}
+	}
+}
+
+// ListWindows returns one line per window with its owner,
+// its number of lines, a 1 if it is active (0 otherwise) and its name.
+func (srv *server) ListWindows() []byte {
+	srv.Lock()
+	defer srv.Unlock()
+
+	names := []string{}
+	for name := range srv.Windows {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	buf := &bytes.Buffer{}
+	for _, name := range names {
+		win, active := srv.Windows[name], 0
+		if win == srv.Active {
+			active = 1
+		}
+
+		fmt.Fprintf(buf, "%d %d %d %s\n", win.Owner, win.NLines, active, name)
+	}
+
+	return buf.Bytes()
 }
 
 func (srv *server) RenderMatrix() []byte {
 	srv.Lock()
 	defer srv.Unlock()
 
-	if srv.Active == nil {
+	if srv.Active == nil && len(srv.Overlays) == 0 {
 		return nil
 	}
 
 	out := ""
-	for _, line := range srv.Active.Render() {
+	for _, line := range srv.render() {
 		out += string(line) + "\n"
 	}
 
@@ -207,151 +686,678 @@ func (srv *server) RenderMatrix() []byte {
 // NETWORK HANDLING //
 //////////////////////
 
-func handleSwitch(srv *server, line string) {
-	name := ""
-	if _, err := fmt.Sscanf(line, "switch %s", &name); err != nil {
-		log.Printf("Failed to parse switch command `%s`: %v", line, err)
+const (
+	// ErrCodeUnknown is replied when the command is not known.
+	ErrCodeUnknown = 1
+
+	// ErrCodeSyntax is replied when the command could not be parsed.
+	ErrCodeSyntax = 2
+
+	// ErrCodeRejected is replied when the command was understood,
+	// but displayd refused to execute it.
+	ErrCodeRejected = 3
+
+	// ErrCodeDenied is replied when the client lacks the permission
+	// for the command (see Config.ControlToken).
+	ErrCodeDenied = 4
+)
+
+// ProtocolError is an error reported by displayd in acknowledged mode.
+// It is sent as "ERR <code> <message>" over the wire.
+type ProtocolError struct {
+	Code    int
+	Message string
+}
+
+func (pe *ProtocolError) Error() string {
+	return fmt.Sprintf("displayd: %s (code %d)", pe.Message, pe.Code)
+}
+
+func syntaxError(format string, args ...interface{}) error {
+	return &ProtocolError{ErrCodeSyntax, fmt.Sprintf(format, args...)}
+}
+
+func rejectedError(err error) error {
+	return &ProtocolError{ErrCodeRejected, err.Error()}
+}
+
+// session is the state of a single client connection.
+type session struct {
+	// ID identifies the connection; used as owner of windows.
+	ID int
+
+	// Conn is the connection to the client.
+	Conn io.ReadWriter
+
+	// Ack is true when every command should be answered with OK or ERR.
+	Ack bool
+
+	// Access is what the client may do; changed by `auth`.
+	Access *util.Access
+
+	// Output is the output the commands of this session go to.
+	Output *server
+
+	// Batches are the batches opened by this session.
+	// They are committed when the session ends.
+	Batches []openBatch
+
+	// Framed is true once the framed protocol was negotiated.
+	Framed bool
+
+	// pending is the output of the last command in the framed protocol.
+	pending []byte
+}
+
+// openBatch is a batch opened on `Window` of the output `Output`.
+type openBatch struct {
+	Output *server
+	Window string
+}
+
+// writeOutput sends the output of commands like render and list.
+// In the framed protocol it becomes part of the response instead.
+func (sess *session) writeOutput(data []byte) {
+	if sess.Framed {
+		sess.pending = data
 		return
 	}
 
-	srv.Switch(name)
+	if err := writeFramed(sess.Conn, data); err != nil {
+		log.Printf("Failed to write output: %v", err)
+	}
+}
+
+// textArity gives the number of arguments each command takes in the text
+// protocol. The last argument may contain spaces and takes the rest of the line.
+var textArity = map[string]int{
+	"switch":    1,
+	"line":      3,
+	"scroll":    3,
+	"move":      2,
+	"truncate":  2,
+	"ack":       1,
+	"begin":     1,
+	"commit":    1,
+	"popup":     4,
+	"dismiss":   1,
+	"destroy":   1,
+	"glyph":     3,
+	"proto":     2,
+	"attr":      3,
+	"widget":    4,
+	"backlight": 1,
+	"contrast":  1,
+	"output":    1,
+	"auth":      1,
+}
+
+// windowCommands are the commands taking a window as first argument.
+// Windows are created by them on demand and owned by the first user.
+var windowCommands = map[string]bool{
+	"switch":   true,
+	"line":     true,
+	"scroll":   true,
+	"attr":     true,
+	"widget":   true,
+	"move":     true,
+	"truncate": true,
+	"begin":    true,
+	"commit":   true,
+	"popup":    true,
+}
+
+// publicCommands may be used without any permission.
+var publicCommands = map[string]bool{
+	"auth":  true,
+	"ack":   true,
+	"proto": true,
+	"close": true,
+}
+
+// readCommands only need read permission; all others need control.
+var readCommands = map[string]bool{
+	"render":  true,
+	"list":    true,
+	"outputs": true,
+	"output":  true,
+}
+
+func commandPermission(cmd string) util.Permission {
+	switch {
+	case publicCommands[cmd]:
+		return util.PermNone
+	case readCommands[cmd]:
+		return util.PermRead
+	default:
+		return util.PermControl
+	}
+}
+
+func splitArgs(cmd, rest string) []string {
+	if rest == "" {
+		return nil
+	}
+
+	n, ok := textArity[cmd]
+	if !ok || n == 1 {
+		return []string{rest}
+	}
+
+	return strings.SplitN(rest, " ", n)
 }
 
-func handleLine(srv *server, line string, split []string) {
+func parseInt(name, arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, syntaxError("Bad %s `%s`", name, arg)
+	}
+
+	return n, nil
+}
+
+func handleSwitch(srv *server, args []string) error {
+	if len(args) < 1 || args[0] == "" {
+		return syntaxError("Usage: switch <win>")
+	}
+
+	srv.Switch(args[0])
+	return nil
+}
+
+func handleLine(srv *server, args []string) error {
+	if len(args) < 2 {
+		return syntaxError("Usage: line <win> <pos> <text>")
+	}
+
+	pos, err := parseInt("line position", args[1])
+	if err != nil {
+		return err
+	}
+
 	text := ""
-	if len(split) >= 4 {
-		text = split[3]
+	if len(args) >= 3 {
+		text = args[2]
 	}
 
-	win, pos := "", 0
+	if err := srv.SetLine(args[0], pos, text); err != nil {
+		return rejectedError(err)
+	}
 
-	if _, err := fmt.Sscanf(line, "line %s %d ", &win, &pos); err != nil {
-		log.Printf("Failed to parse line command `%s`: %v", line, err)
-		return
+	return nil
+}
+
+func handleScroll(srv *server, args []string) error {
+	if len(args) < 3 {
+		return syntaxError("Usage: scroll <win> <pos> <delay>")
 	}
 
-	if err := srv.SetLine(win, pos, text); err != nil {
-		log.Printf("Failed to set line: %v", err)
-		return
+	pos, err := parseInt("line position", args[1])
+	if err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(args[2])
+	if err != nil {
+		return syntaxError("Bad duration `%s`: %v", args[2], err)
+	}
+
+	if err := srv.SetScrollDelay(args[0], pos, duration); err != nil {
+		return rejectedError(err)
 	}
+
+	return nil
 }
 
-func handleScroll(srv *server, line string) {
-	win, pos, durationSpec := "", 0, ""
-	if _, err := fmt.Sscanf(line, "scroll %s %d %s", &win, &pos, &durationSpec); err != nil {
-		log.Printf("Failed to parse scroll command `%s`: %v", line, err)
-		return
+func handleAttr(srv *server, args []string) error {
+	if len(args) < 3 {
+		return syntaxError("Usage: attr <win> <pos> <key>=<value>...")
 	}
 
-	duration, err := time.ParseDuration(durationSpec)
+	pos, err := parseInt("line position", args[1])
 	if err != nil {
-		log.Printf("Bad duration `%s`: %v", durationSpec, err)
-		return
+		return err
 	}
 
-	if err := srv.SetScrollDelay(win, pos, duration); err != nil {
-		log.Printf("Cannot set scroll: %v", err)
-		return
+	attrs, err := parseAttrs(args[2:])
+	if err != nil {
+		return syntaxError("%v", err)
+	}
+
+	if err := srv.SetAttrs(args[0], pos, attrs); err != nil {
+		return rejectedError(err)
+	}
+
+	return nil
+}
+
+func handleWidget(srv *server, args []string) error {
+	if len(args) < 3 {
+		return syntaxError("Usage: widget <win> <pos> <kind> [<spec>]")
+	}
+
+	pos, err := parseInt("line position", args[1])
+	if err != nil {
+		return err
 	}
+
+	widget, err := parseWidget(args[2], strings.Join(args[3:], " "), time.Now())
+	if err != nil {
+		return syntaxError("%v", err)
+	}
+
+	if err := srv.SetWidget(args[0], pos, widget); err != nil {
+		return rejectedError(err)
+	}
+
+	return nil
 }
 
-func parseMoveTruncate(line string) (string, int, error) {
-	name, dummy, pos := "", "", 0
-	if _, err := fmt.Sscanf(line, "%s %s %d", &dummy, &name, &pos); err != nil {
-		log.Printf("Failed to parse move command `%s`: %v", line, err)
+func parseMoveTruncate(name string, args []string) (string, int, error) {
+	if len(args) < 2 {
+		return "", 0, syntaxError("Usage: %s <win> <n>", name)
+	}
+
+	n, err := parseInt("line count", args[1])
+	if err != nil {
 		return "", 0, err
 	}
 
-	return name, pos, nil
+	return args[0], n, nil
 }
 
-func handleMove(srv *server, line string) {
-	name, pos, err := parseMoveTruncate(line)
+func handleMove(srv *server, args []string) error {
+	name, n, err := parseMoveTruncate("move", args)
 	if err != nil {
-		return
+		return err
 	}
 
-	srv.Move(name, pos)
+	srv.Move(name, n)
+	return nil
 }
 
-func handleTruncate(srv *server, line string) {
-	name, pos, err := parseMoveTruncate(line)
+func handleTruncate(srv *server, args []string) error {
+	name, n, err := parseMoveTruncate("truncate", args)
 	if err != nil {
-		return
+		return err
 	}
 
-	srv.Truncate(name, pos)
+	srv.Truncate(name, n)
+	return nil
 }
 
-func handleRender(srv *server, conn io.Writer) {
-	matrix := srv.RenderMatrix()
-	sizeBuf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(sizeBuf, uint64(len(matrix)))
+func handleBegin(srv *server, sess *session, args []string) error {
+	if len(args) < 1 || args[0] == "" {
+		return syntaxError("Usage: begin <win>")
+	}
 
-	if _, err := conn.Write(sizeBuf); err != nil {
-		log.Printf("Failed to respond rendered size: %v", err)
-		return
+	srv.Begin(args[0])
+	sess.Batches = append(sess.Batches, openBatch{srv, args[0]})
+	return nil
+}
+
+func handleCommit(srv *server, sess *session, args []string) error {
+	if len(args) < 1 || args[0] == "" {
+		return syntaxError("Usage: commit <win>")
 	}
 
-	if _, err := conn.Write(matrix); err != nil {
-		log.Printf("Failed to respond rendered display: %v", err)
-		return
+	for idx := len(sess.Batches) - 1; idx >= 0; idx-- {
+		if sess.Batches[idx] == (openBatch{srv, args[0]}) {
+			sess.Batches = append(sess.Batches[:idx], sess.Batches[idx+1:]...)
+			if err := srv.Commit(args[0]); err != nil {
+				return rejectedError(err)
+			}
+
+			return nil
+		}
 	}
+
+	return rejectedError(fmt.Errorf("No batch open on window `%s`", args[0]))
 }
 
-func handleSingle(srv *server, line string, conn io.Writer) bool {
-	switch split := strings.SplitN(line, " ", 4); split[0] {
+func handlePopup(srv *server, args []string) error {
+	if len(args) < 2 {
+		return syntaxError("Usage: popup <win> <duration> [<row> [<rows>]]")
+	}
+
+	duration, err := time.ParseDuration(args[1])
+	if err != nil {
+		return syntaxError("Bad duration `%s`: %v", args[1], err)
+	}
+
+	row, rows := 0, 0
+	if len(args) >= 3 {
+		if row, err = parseInt("row", args[2]); err != nil {
+			return err
+		}
+	}
+
+	if len(args) >= 4 {
+		if rows, err = parseInt("row count", args[3]); err != nil {
+			return err
+		}
+	}
+
+	if err := srv.Popup(args[0], duration, row, rows); err != nil {
+		return rejectedError(err)
+	}
+
+	return nil
+}
+
+func handleDismiss(srv *server, args []string) error {
+	if len(args) < 1 || args[0] == "" {
+		return syntaxError("Usage: dismiss <win>")
+	}
+
+	if err := srv.Dismiss(args[0]); err != nil {
+		return rejectedError(err)
+	}
+
+	return nil
+}
+
+func handleDestroy(srv *server, args []string) error {
+	if len(args) < 1 || args[0] == "" {
+		return syntaxError("Usage: destroy <win>")
+	}
+
+	if err := srv.Destroy(args[0]); err != nil {
+		return rejectedError(err)
+	}
+
+	return nil
+}
+
+func handleGlyph(srv *server, args []string) error {
+	const usage = "Usage: glyph <slot> <bitmap> or glyph <win> <char> <bitmap>"
+	if len(args) < 2 || args[0] == "" {
+		return syntaxError(usage)
+	}
+
+	glyph, err := ParseGlyph(args[len(args)-1])
+	if err != nil {
+		return syntaxError("%v", err)
+	}
+
+	if len(args) == 2 {
+		slot, err := parseInt("slot", args[0])
+		if err != nil {
+			return err
+		}
+
+		if err := srv.DefineGlyph(slot, glyph); err != nil {
+			return rejectedError(err)
+		}
+
+		return nil
+	}
+
+	rn, size := utf8.DecodeRuneInString(args[1])
+	if rn == utf8.RuneError || size != len(args[1]) {
+		return syntaxError("Bad glyph char `%s` (exactly one expected)", args[1])
+	}
+
+	if err := srv.AllocGlyph(args[0], rn, glyph); err != nil {
+		return rejectedError(err)
+	}
+
+	return nil
+}
+
+func handleLevel(name string, set func(int) error, args []string) error {
+	if len(args) < 1 || args[0] == "" {
+		return syntaxError("Usage: %s <level>", name)
+	}
+
+	level, err := parseInt("level", args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := set(level); err != nil {
+		return rejectedError(err)
+	}
+
+	return nil
+}
+
+func handleAuth(sess *session, args []string) error {
+	if len(args) < 1 || args[0] == "" {
+		return syntaxError("Usage: auth <token>")
+	}
+
+	if err := sess.Access.Auth(args[0]); err != nil {
+		return &ProtocolError{ErrCodeDenied, err.Error()}
+	}
+
+	return nil
+}
+
+func handleAck(sess *session, args []string) error {
+	switch {
+	case len(args) == 0 || args[0] == "on":
+		sess.Ack = true
+	case args[0] == "off":
+		sess.Ack = false
+	default:
+		return syntaxError("Usage: ack [on|off]")
+	}
+
+	return nil
+}
+
+// errorCode splits `err` into one of the ErrCode* constants and a message.
+func errorCode(err error) (int, string) {
+	if protoErr, ok := err.(*ProtocolError); ok {
+		return protoErr.Code, protoErr.Message
+	}
+
+	return ErrCodeRejected, err.Error()
+}
+
+func writeReply(conn io.Writer, err error) {
+	reply := "OK\n"
+	if err != nil {
+		code, msg := errorCode(err)
+
+		// The message must not break the line based protocol:
+		msg = strings.Replace(msg, "\n", " ", -1)
+		reply = fmt.Sprintf("ERR %d %s\n", code, msg)
+	}
+
+	if _, err := conn.Write([]byte(reply)); err != nil {
+		log.Printf("Failed to write reply: %v", err)
+	}
+}
+
+// dispatch executes `cmd` with `args`. It returns false if the connection
+// should be closed afterwards and true if a reply still needs to be sent.
+func dispatch(dd *displayd, sess *session, cmd string, args []string) (keepGoing, needsReply bool, err error) {
+	if err := sess.Access.Check(cmd, commandPermission(cmd)); err != nil {
+		return true, sess.Ack, &ProtocolError{ErrCodeDenied, err.Error()}
+	}
+
+	// Only successful commands are recorded, so a replay does not fail.
+	// The end of the session is recorded as close by handleAll;
+	// tokens have no business in session files:
+	defer func() {
+		if err == nil && cmd != "close" && cmd != "auth" {
+			dd.Record(sess.ID, cmd, args)
+		}
+	}()
+
+	srv := sess.Output
+
+	// Commands that may change something (input included) end the
+	// screensaver; polling with render or list must not keep it away:
+	if commandPermission(cmd) == util.PermControl {
+		srv.Wake()
+	}
+
+	switch cmd {
 	case "switch":
-		handleSwitch(srv, line)
+		err = handleSwitch(srv, args)
 	case "line":
-		handleLine(srv, line, split)
+		err = handleLine(srv, args)
 	case "scroll":
-		handleScroll(srv, line)
+		err = handleScroll(srv, args)
+	case "attr":
+		err = handleAttr(srv, args)
+	case "widget":
+		err = handleWidget(srv, args)
 	case "move":
-		handleMove(srv, line)
+		err = handleMove(srv, args)
 	case "truncate":
-		handleTruncate(srv, line)
+		err = handleTruncate(srv, args)
+	case "begin":
+		err = handleBegin(srv, sess, args)
+	case "commit":
+		err = handleCommit(srv, sess, args)
+	case "popup":
+		err = handlePopup(srv, args)
+	case "dismiss":
+		err = handleDismiss(srv, args)
+	case "backlight":
+		err = handleLevel(cmd, srv.SetBacklight, args)
+	case "contrast":
+		err = handleLevel(cmd, srv.SetContrast, args)
+	case "input":
+		dd.Input()
+	case "output":
+		err = handleOutput(dd, sess, args)
+	case "outputs":
+		sess.writeOutput(dd.ListOutputs())
+		return true, false, nil
+	case "auth":
+		err = handleAuth(sess, args)
+	case "ack":
+		// Always answered, so clients can synchronize on it:
+		return true, true, handleAck(sess, args)
+	case "proto":
+		// Always answered in the text protocol; the framed one starts after.
+		return true, true, handleProto(sess, args)
+	case "destroy":
+		err = handleDestroy(srv, args)
+	case "glyph":
+		err = handleGlyph(srv, args)
 	case "render":
 		// NOTE: This is only used for --dump, not for the actual driver.
-		handleRender(srv, conn)
+		//       The rendered matrix is the reply.
+		sess.writeOutput(srv.RenderMatrix())
+		return true, false, nil
+	case "list":
+		sess.writeOutput(srv.ListWindows())
+		return true, false, nil
 	case "close":
-		return false
+		return false, false, nil
 	case "quit":
-		srv.Quit <- true
-		return false
+		dd.Quit <- true
+		return false, false, nil
 	default:
-		log.Printf("Ignoring unknown command `%s`", line)
+		err = &ProtocolError{ErrCodeUnknown, fmt.Sprintf("Unknown command `%s`", cmd)}
+	}
+
+	// Only adopt after the handler checked the arguments,
+	// so bad commands do not leave windows behind:
+	takesWindow := windowCommands[cmd] || (cmd == "glyph" && len(args) == 3)
+	if err == nil && takesWindow && len(args) > 0 && args[0] != "" {
+		srv.Adopt(args[0], sess.ID)
+	}
+
+	return true, sess.Ack, err
+}
+
+func handleSingle(dd *displayd, sess *session, line string) bool {
+	cmd, rest := line, ""
+	if split := strings.SplitN(line, " ", 2); len(split) > 1 {
+		cmd, rest = split[0], split[1]
+	}
+
+	keepGoing, needsReply, err := dispatch(dd, sess, cmd, splitArgs(cmd, rest))
+	if err != nil {
+		log.Printf("Failed to execute `%s`: %v", line, err)
+	}
+
+	if needsReply {
+		writeReply(sess.Conn, err)
 	}
 
-	return true
+	return keepGoing
 }
 
-func handleAll(srv *server, conn io.ReadWriteCloser) {
-	scanner := bufio.NewScanner(conn)
+// maxLineLength limits a line of the text protocol, like bufio.Scanner does.
+const maxLineLength = bufio.MaxScanTokenSize
+
+// readLine reads a line of at most maxLineLength bytes from `reader`.
+// Unlike bufio.Scanner it leaves the rest of `reader` to the framed protocol.
+func readLine(reader *bufio.Reader) (string, error) {
+	line := []byte{}
+
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+
+		if len(line) > maxLineLength {
+			return "", fmt.Errorf("Line is longer than %d bytes", maxLineLength)
+		}
+
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+func handleAll(dd *displayd, conn io.ReadWriteCloser) {
+	reader := bufio.NewReader(conn)
 	defer util.Closer(conn)
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) == 0 {
-			continue
+	sess := &session{
+		ID:     dd.newSessionID(),
+		Conn:   conn,
+		Output: dd.Outputs[DefaultOutput],
+		Access: util.NewAccess(dd.Tokens),
+	}
+
+	for {
+		line, err := readLine(reader)
+		line = strings.TrimRight(line, "\r\n")
+
+		if len(line) > 0 && !handleSingle(dd, sess, line) {
+			break
 		}
 
-		if !handleSingle(srv, line, conn) {
+		if sess.Framed {
+			handleFramed(dd, sess, reader)
+			break
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Reading connection failed: %v", err)
+			}
+
 			break
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("Reading connection failed: %v", err)
+	// Do not leave windows frozen forever:
+	for _, batch := range sess.Batches {
+		if err := batch.Output.Commit(batch.Window); err != nil {
+			log.Printf("Failed to commit left-over batch: %v", err)
+		}
 	}
+
+	dd.ReleaseWindows(sess.ID)
+	dd.Record(sess.ID, "close", nil)
 }
 
-func aborted(srv *server, ctx context.Context) bool {
+func aborted(dd *displayd, ctx context.Context) bool {
 	// Check if we were interrupted:
 	select {
 	case <-ctx.Done():
 		return true
-	case <-srv.Quit:
+	case <-dd.Quit:
 		return true
 	default:
 		return false
@@ -360,40 +1366,36 @@ func aborted(srv *server, ctx context.Context) bool {
 
 // Run starts a new displayd server based on `cfg` and `ctx`.
 func Run(cfg *Config, ctx context.Context) error {
-	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
-	lsn, err := net.Listen("tcp", addr)
+	lsn, err := util.Listen(cfg.Host, cfg.Port)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Listening on %s", addr)
+	log.Printf("Listening on %s", lsn.Addr())
 
 	defer util.Closer(lsn)
 
-	srv, err := newServer(cfg, ctx)
+	dd, err := newDisplayd(cfg, ctx)
 	if err != nil {
 		return err
 	}
 
-	for !aborted(srv, ctx) {
-		if tcpLsn, ok := lsn.(*net.TCPListener); ok {
-			if err := tcpLsn.SetDeadline(time.Now().Add(2 * time.Second)); err != nil {
-				log.Printf("Setting deadline failed: %v", err)
-				return err
-			}
-		}
+	if dd.Recorder != nil {
+		defer util.Closer(dd.Recorder)
+	}
 
-		conn, err := lsn.Accept()
-		if err, ok := err.(*net.OpError); ok && err.Timeout() {
+	for !aborted(dd, ctx) {
+		conn, err := util.Accept(lsn, 2*time.Second)
+		if err != nil {
+			log.Printf("Failed to accept connection: %v", err)
 			continue
 		}
 
-		if err != nil {
-			log.Printf("Failed to accept connection: %v", err)
+		if conn == nil {
 			continue
 		}
 
-		go handleAll(srv, conn)
+		go handleAll(dd, conn)
 	}
 
 	return nil
@@ -0,0 +1,93 @@
+package display
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAckReplies(t *testing.T) {
+	dd, _ := newTestDisplayd(t, "")
+	conn, _ := connect(dd)
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	tcs := []struct {
+		line, reply string
+	}{
+		{"ack", "OK"},
+		{"line w 0 Hello", "OK"},
+		{"switch w", "OK"},
+		{"line w first Hello", "ERR 2 Bad line position `first`"},
+		{"line w", "ERR 2 Usage: line <win> <pos> <text>"},
+		{"blink w", "ERR 1 Unknown command `blink`"},
+		{"ack maybe", "ERR 2 Usage: ack [on|off]"},
+		{"ack off", "OK"},
+		// Errors are not answered either; the next reply is the one of ack:
+		{"line w 1 quiet", ""},
+		{"blink w", ""},
+		{"ack on", "OK"},
+	}
+
+	for _, tc := range tcs {
+		if _, err := conn.Write([]byte(tc.line + "\n")); err != nil {
+			t.Fatalf("Failed to send `%s`: %v", tc.line, err)
+		}
+
+		if tc.reply == "" {
+			continue
+		}
+
+		reply, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read reply to `%s`: %v", tc.line, err)
+		}
+
+		if reply != tc.reply+"\n" {
+			t.Errorf("`%s` was answered with `%s` (want `%s`)", tc.line, strings.TrimSpace(reply), tc.reply)
+		}
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	long := strings.Repeat("x", maxLineLength-1)
+	input := "short\n" + long + "\nrest"
+
+	// A small buffer makes readLine go over several chunks:
+	reader := bufio.NewReaderSize(strings.NewReader(input), 16)
+
+	for _, want := range []string{"short\n", long + "\n"} {
+		line, err := readLine(reader)
+		if err != nil || line != want {
+			t.Fatalf("Read %d bytes (want %d): %v", len(line), len(want), err)
+		}
+	}
+
+	// What follows is left for the framed protocol:
+	if line, err := readLine(reader); line != "rest" || err == nil {
+		t.Errorf("Read `%s` with %v at the end", line, err)
+	}
+
+	reader = bufio.NewReaderSize(strings.NewReader(long+"xx\n"), 16)
+	if _, err := readLine(reader); err == nil {
+		t.Errorf("Line of %d bytes was accepted", maxLineLength+1)
+	}
+}
+
+func TestOversizedLineCloses(t *testing.T) {
+	dd, _ := newTestDisplayd(t, "")
+	conn, done := connect(dd)
+	defer conn.Close()
+
+	go func() {
+		// Fails once the other side gave up reading:
+		conn.Write([]byte(strings.Repeat("x", 2*maxLineLength) + "\n"))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Connection was not closed after an oversized line")
+	}
+}
@@ -0,0 +1,381 @@
+package display
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// VirtualDriverName can be passed as Config.DriverBinary to use an
+	// in-process VirtualLCD instead of starting an external driver.
+	VirtualDriverName = "virtual"
+
+	// DefaultMaxFrames is the number of frames a VirtualLCD remembers.
+	DefaultMaxFrames = 1024
+)
+
+// Frame is a snapshot of the whole LCD matrix at a certain time.
+type Frame struct {
+	// Time is the time when the frame was completed.
+	Time time.Time
+
+	// Matrix contains `Height` rows of `Width` LCD codepoints each.
+	Matrix [][]rune
+
+	// Rows is Matrix decoded to readable utf8, one string per row.
+	Rows []string
+}
+
+// String returns the frame as readable, utf8 encoded text.
+// Each row is framed by a border so trailing spaces are visible.
+func (fr *Frame) String() string {
+	width := 0
+	if len(fr.Matrix) > 0 {
+		width = len(fr.Matrix[0])
+	}
+
+	border := "+" + strings.Repeat("-", width) + "+\n"
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(border)
+
+	for _, row := range fr.Rows {
+		buf.WriteString("|" + row + "|\n")
+	}
+
+	buf.WriteString(border)
+	return buf.String()
+}
+
+// VirtualLCD is a headless driver that understands the same protocol
+// as the radio-lcd driver. Instead of talking to real hardware it keeps the
+// full Width x Height matrix in memory and records every frame.
+// It can be passed as Config.Driver to displayd.
+type VirtualLCD struct {
+	sync.Mutex
+
+	// Width is the number of characters per row.
+	Width int
+
+	// Height is the number of rows.
+	Height int
+
+	// MaxFrames limits the number of remembered frames.
+	// Older frames are dropped first.
+	MaxFrames int
+
+	matrix  [][]rune
+	frames  []Frame
+	partial []byte
+	glyphs  [NGlyphSlots]Glyph
+
+	// encoder tells how the text bytes are split into codepoints;
+	// decode turns them back into readable text. See attach().
+	encoder Encoder
+	decode  func(encoded []rune) string
+
+	backlight, contrast int
+}
+
+// NewVirtualLCD returns a new, blank VirtualLCD with `w`x`h` characters.
+func NewVirtualLCD(w, h int) *VirtualLCD {
+	vl := &VirtualLCD{
+		Width:     w,
+		Height:    h,
+		MaxFrames: DefaultMaxFrames,
+		glyphs:    defaultGlyphs,
+		backlight: DefaultBacklight,
+		contrast:  DefaultContrast,
+	}
+
+	vl.attach(encoders[DefaultEncoding], nil)
+
+	for i := 0; i < h; i++ {
+		vl.matrix = append(vl.matrix, []rune(strings.Repeat(" ", w)))
+	}
+
+	return vl
+}
+
+// attach makes the virtual LCD read its input like a display using `enc`.
+// `decode` makes frames readable again; it should know the loaded glyphs.
+// If it is nil, enc.Decode is used.
+func (vl *VirtualLCD) attach(enc Encoder, decode func(encoded []rune) string) {
+	vl.Lock()
+	defer vl.Unlock()
+
+	if decode == nil {
+		decode = enc.Decode
+	}
+
+	vl.encoder, vl.decode = enc, decode
+}
+
+// codepoints splits text sent by the server into LCD codepoints.
+func (vl *VirtualLCD) codepoints(text []byte) []rune {
+	if _, ok := vl.encoder.(utf8Encoder); ok {
+		return []rune(string(text))
+	}
+
+	encoded := make([]rune, len(text))
+	for idx, b := range text {
+		encoded[idx] = rune(b)
+	}
+
+	return encoded
+}
+
+// Write feeds driver protocol lines to the virtual LCD.
+// Incomplete lines are buffered until the next newline arrives.
+func (vl *VirtualLCD) Write(p []byte) (int, error) {
+	vl.Lock()
+	defer vl.Unlock()
+
+	vl.partial = append(vl.partial, p...)
+
+	for {
+		idx := bytes.IndexByte(vl.partial, '\n')
+		if idx < 0 {
+			break
+		}
+
+		line := vl.partial[:idx]
+		vl.partial = vl.partial[idx+1:]
+
+		if err := vl.handleLine(line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (vl *VirtualLCD) handleLine(line []byte) error {
+	if bytes.HasPrefix(line, []byte("!")) {
+		return vl.handleControl(string(line))
+	}
+
+	spaceIdx := bytes.IndexByte(line, ' ')
+	if spaceIdx < 0 {
+		return fmt.Errorf("Bad driver line `%s`", line)
+	}
+
+	header, text := string(line[:spaceIdx]), line[spaceIdx+1:]
+	posSpec, offSpec := header, ""
+	if split := strings.SplitN(header, ",", 2); len(split) > 1 {
+		posSpec, offSpec = split[0], split[1]
+	}
+
+	pos, err := strconv.Atoi(posSpec)
+	if err != nil {
+		return fmt.Errorf("Bad line number `%s`: %v", posSpec, err)
+	}
+
+	if pos < 0 || pos >= vl.Height {
+		// The real driver ignores those silently too.
+		return nil
+	}
+
+	off := 0
+	if offSpec != "" {
+		if off, err = strconv.Atoi(offSpec); err != nil {
+			return fmt.Errorf("Bad offset `%s`: %v", offSpec, err)
+		}
+	}
+
+	// Mimic the driver: Text ends at the first zero byte.
+	if nulIdx := bytes.IndexByte(text, 0); nulIdx >= 0 {
+		text = text[:nulIdx]
+	}
+
+	row, encoded := vl.matrix[pos], vl.codepoints(text)
+
+	i := off
+	for ; i < vl.Width && i-off < len(encoded); i++ {
+		row[i] = encoded[i-off]
+	}
+
+	// Without offset the rest of the line gets cleared:
+	if offSpec == "" {
+		for ; i < vl.Width; i++ {
+			row[i] = ' '
+		}
+	}
+
+	return nil
+}
+
+// handleControl handles driver lines that do not contain text:
+//
+//	!glyph <slot> <bitmap>
+//	!backlight <level>
+//	!contrast <level>
+func (vl *VirtualLCD) handleControl(line string) error {
+	split := strings.Fields(line)
+	switch {
+	case len(split) == 3 && split[0] == "!glyph":
+		return vl.handleGlyph(split)
+	case len(split) == 2 && split[0] == "!backlight":
+		return parseLevel(split[1], &vl.backlight)
+	case len(split) == 2 && split[0] == "!contrast":
+		return parseLevel(split[1], &vl.contrast)
+	}
+
+	return fmt.Errorf("Bad control line `%s`", line)
+}
+
+func parseLevel(spec string, level *int) error {
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 0 || n > MaxLevel {
+		return fmt.Errorf("Bad level `%s`", spec)
+	}
+
+	*level = n
+	return nil
+}
+
+func (vl *VirtualLCD) handleGlyph(split []string) error {
+	slot, err := strconv.Atoi(split[1])
+	if err != nil || slot < 0 || slot >= NGlyphSlots {
+		return fmt.Errorf("Bad glyph slot `%s`", split[1])
+	}
+
+	glyph, err := ParseGlyph(split[2])
+	if err != nil {
+		return err
+	}
+
+	vl.glyphs[slot] = glyph
+	return nil
+}
+
+// Glyphs returns the current contents of the programmable slots.
+func (vl *VirtualLCD) Glyphs() [NGlyphSlots]Glyph {
+	vl.Lock()
+	defer vl.Unlock()
+
+	return vl.glyphs
+}
+
+// Backlight returns the current backlight level (0-100).
+func (vl *VirtualLCD) Backlight() int {
+	vl.Lock()
+	defer vl.Unlock()
+
+	return vl.backlight
+}
+
+// Contrast returns the current contrast level (0-100).
+func (vl *VirtualLCD) Contrast() int {
+	vl.Lock()
+	defer vl.Unlock()
+
+	return vl.contrast
+}
+
+// Flush marks the end of a frame and records the current matrix.
+func (vl *VirtualLCD) Flush() error {
+	vl.Lock()
+	defer vl.Unlock()
+
+	frame := Frame{
+		Time:   time.Now(),
+		Matrix: vl.copyMatrix(),
+	}
+
+	for _, row := range frame.Matrix {
+		frame.Rows = append(frame.Rows, vl.decode(row))
+	}
+
+	vl.frames = append(vl.frames, frame)
+	if vl.MaxFrames > 0 && len(vl.frames) > vl.MaxFrames {
+		vl.frames = vl.frames[len(vl.frames)-vl.MaxFrames:]
+	}
+
+	return nil
+}
+
+func (vl *VirtualLCD) copyMatrix() [][]rune {
+	matrix := make([][]rune, len(vl.matrix))
+	for idx, row := range vl.matrix {
+		matrix[idx] = append([]rune{}, row...)
+	}
+
+	return matrix
+}
+
+// Matrix returns a copy of the current LCD contents as codepoints.
+func (vl *VirtualLCD) Matrix() [][]rune {
+	vl.Lock()
+	defer vl.Unlock()
+
+	return vl.copyMatrix()
+}
+
+// Frames returns all recorded frames, oldest first.
+func (vl *VirtualLCD) Frames() []Frame {
+	vl.Lock()
+	defer vl.Unlock()
+
+	return append([]Frame{}, vl.frames...)
+}
+
+// LastFrame returns the most recently recorded frame.
+// It returns nil if no frame was recorded yet.
+func (vl *VirtualLCD) LastFrame() *Frame {
+	vl.Lock()
+	defer vl.Unlock()
+
+	if len(vl.frames) == 0 {
+		return nil
+	}
+
+	frame := vl.frames[len(vl.frames)-1]
+	return &frame
+}
+
+// Reset forgets all recorded frames.
+func (vl *VirtualLCD) Reset() {
+	vl.Lock()
+	defer vl.Unlock()
+
+	vl.frames = nil
+}
+
+// CompareGolden checks if `frames` match the golden file at `path`.
+// If `update` is true, the golden file is (re-)written instead.
+// Timestamps are not part of the golden file.
+func CompareGolden(path string, update bool, frames ...Frame) error {
+	buf := &bytes.Buffer{}
+	for idx := range frames {
+		buf.WriteString(frames[idx].String())
+	}
+
+	if update {
+		return ioutil.WriteFile(path, buf.Bytes(), 0644)
+	}
+
+	golden, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("No golden file at `%s` (update it first)", path)
+		}
+
+		return err
+	}
+
+	if !bytes.Equal(golden, buf.Bytes()) {
+		return fmt.Errorf(
+			"Frames differ from `%s`:\n--- want:\n%s--- got:\n%s",
+			path, golden, buf.Bytes(),
+		)
+	}
+
+	return nil
+}
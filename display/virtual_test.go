@@ -0,0 +1,132 @@
+package display
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata/")
+
+// newTestServer returns a server that renders to a VirtualLCD.
+// Its render loop is not running; frames are only made by calling update().
+func newTestServer(t *testing.T, encoding string, w, h int) (*server, *VirtualLCD) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	vl := NewVirtualLCD(w, h)
+	srv, err := newServer(&Config{
+		Width:    w,
+		Height:   h,
+		Driver:   vl,
+		Encoding: encoding,
+	}, ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	return srv, vl
+}
+
+// run executes `cmds` like they would arrive on a connection.
+func run(t *testing.T, srv *server, cmds ...[]string) {
+	handlers := map[string]func(*server, []string) error{
+		"switch": handleSwitch,
+		"line":   handleLine,
+		"scroll": handleScroll,
+		"attr":   handleAttr,
+		"glyph":  handleGlyph,
+	}
+
+	for _, cmd := range cmds {
+		if err := handlers[cmd[0]](srv, cmd[1:]); err != nil {
+			t.Fatalf("Command `%v` failed: %v", cmd, err)
+		}
+	}
+}
+
+func checkGolden(t *testing.T, name string, frames []Frame) {
+	path := filepath.Join("testdata", name+".golden")
+	if err := CompareGolden(path, *update, frames...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLineFrames(t *testing.T) {
+	srv, vl := newTestServer(t, "", 10, 2)
+	run(t, srv,
+		[]string{"line", "w", "0", "Hello"},
+		[]string{"line", "w", "1", "too long for the display"},
+		[]string{"switch", "w"},
+	)
+
+	now := time.Now()
+	srv.update(now)
+
+	run(t, srv,
+		[]string{"line", "w", "0", "Bye"},
+		[]string{"line", "other", "0", "hidden"},
+	)
+
+	srv.update(now.Add(time.Second))
+	checkGolden(t, "lines", vl.Frames())
+}
+
+func TestScrollFrames(t *testing.T) {
+	for _, mode := range []string{ScrollWrap, ScrollBounce, ScrollOnce} {
+		srv, vl := newTestServer(t, "", 10, 2)
+		run(t, srv,
+			[]string{"attr", "w", "0", "scroll=" + mode},
+			[]string{"line", "w", "0", "Eulenfunk radio"},
+			[]string{"line", "w", "1", "static"},
+			[]string{"scroll", "w", "0", "100ms"},
+		)
+
+		// One step is longer than the scroll delay, so each update
+		// shifts the line exactly once:
+		now := time.Now()
+		for i := 0; i < 30; i++ {
+			srv.update(now.Add(time.Duration(i) * time.Second))
+		}
+
+		checkGolden(t, "scroll-"+mode, vl.Frames())
+	}
+}
+
+func TestAlignFrames(t *testing.T) {
+	srv, vl := newTestServer(t, "", 12, 4)
+	run(t, srv,
+		[]string{"attr", "w", "0", "align=left"},
+		[]string{"attr", "w", "1", "align=center"},
+		[]string{"attr", "w", "2", "align=right"},
+		[]string{"attr", "w", "3", "align=center fill=━"},
+		[]string{"line", "w", "0", "left"},
+		[]string{"line", "w", "1", "center"},
+		[]string{"line", "w", "2", "right"},
+		[]string{"line", "w", "3", " ❤ "},
+	)
+
+	srv.update(time.Now())
+	checkGolden(t, "align", vl.Frames())
+}
+
+func TestEncodingFrames(t *testing.T) {
+	for _, encoding := range EncoderNames() {
+		srv, vl := newTestServer(t, encoding, 16, 4)
+		run(t, srv,
+			[]string{"line", "w", "0", "Grüße ❤ 5€ 20°"},
+			[]string{"line", "w", "1", "α→π ×÷ ｶﾀｶﾅ"},
+			[]string{"glyph", "w", "☺", "00,0a,0a,00,11,0e,00,00"},
+			[]string{"line", "w", "2", "☺ ▶ ⏸ ⏹ ✓ ━"},
+			[]string{"glyph", "1", "00,04,0e,1f,0e,04,00,00"},
+			[]string{"line", "w", "3", "▶ redefined"},
+		)
+
+		srv.update(time.Now())
+		checkGolden(t, "encoding-"+encoding, vl.Frames())
+	}
+}
@@ -0,0 +1,260 @@
+package display
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Possible states of a TimeSpan:
+const (
+	// SpanRunning means the elapsed time advances.
+	SpanRunning = "running"
+
+	// SpanPaused means the elapsed time stays where it is.
+	SpanPaused = "paused"
+)
+
+// DefaultClockLayout is used by clock widgets without a layout.
+const DefaultClockLayout = "15:04:05"
+
+// DefaultTimerFormat is used by timer widgets without a format.
+const DefaultTimerFormat = "%e/%t"
+
+// Widget generates the text of a line from the current time.
+// displayd re-renders it on its own, so clients only need to declare it once.
+type Widget interface {
+	// Text returns the text of the widget at `now` for a line `width` long.
+	Text(now time.Time, width int) string
+
+	// Next returns when the text changes next; zero if it never does.
+	Next(now time.Time, width int) time.Time
+}
+
+// TimeSpan is a (possibly paused) span of time, like the position in a song.
+type TimeSpan struct {
+	// Elapsed is the time elapsed at the moment the span was declared.
+	Elapsed time.Duration
+
+	// Duration is the total length; zero if unknown (e.g. for radio streams).
+	Duration time.Duration
+
+	// Paused is true if the elapsed time does not advance.
+	Paused bool
+
+	// start is the time when Elapsed was zero.
+	start time.Time
+}
+
+// String returns the span in the format understood by ParseTimeSpan.
+func (ts TimeSpan) String() string {
+	state := SpanRunning
+	if ts.Paused {
+		state = SpanPaused
+	}
+
+	return fmt.Sprintf("%s %s %s", ts.Elapsed, ts.Duration, state)
+}
+
+// ParseTimeSpan parses "<elapsed> <duration> <running|paused>",
+// e.g. "1m3.5s 4m20s running". The span starts relative to `now`.
+func ParseTimeSpan(fields []string, now time.Time) (TimeSpan, error) {
+	ts := TimeSpan{}
+
+	if len(fields) != 3 {
+		return ts, fmt.Errorf("Time span needs <elapsed> <duration> <%s|%s>", SpanRunning, SpanPaused)
+	}
+
+	var err error
+	if ts.Elapsed, err = time.ParseDuration(fields[0]); err != nil || ts.Elapsed < 0 {
+		return ts, fmt.Errorf("Bad elapsed time `%s`", fields[0])
+	}
+
+	if ts.Duration, err = time.ParseDuration(fields[1]); err != nil || ts.Duration < 0 {
+		return ts, fmt.Errorf("Bad duration `%s`", fields[1])
+	}
+
+	switch fields[2] {
+	case SpanRunning:
+	case SpanPaused:
+		ts.Paused = true
+	default:
+		return ts, fmt.Errorf("Bad time span state `%s`", fields[2])
+	}
+
+	ts.start = now.Add(-ts.Elapsed)
+	return ts, nil
+}
+
+// elapsed returns the elapsed time at `now`, not more than the duration.
+func (ts TimeSpan) elapsed(now time.Time) time.Duration {
+	elapsed := ts.Elapsed
+	if !ts.Paused {
+		elapsed = now.Sub(ts.start)
+	}
+
+	if ts.Duration > 0 && elapsed > ts.Duration {
+		elapsed = ts.Duration
+	}
+
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return elapsed
+}
+
+// next returns the time when the span reaches the next multiple of `step`.
+// It returns the zero time if that will never happen.
+func (ts TimeSpan) next(now time.Time, step time.Duration) time.Time {
+	if ts.Paused || step <= 0 {
+		return time.Time{}
+	}
+
+	elapsed := ts.elapsed(now)
+	if ts.Duration > 0 && elapsed >= ts.Duration {
+		return time.Time{}
+	}
+
+	return ts.start.Add((elapsed/step + 1) * step)
+}
+
+// clockWidget shows the current time formatted by a Go time layout.
+type clockWidget struct {
+	Layout string
+}
+
+func (cw *clockWidget) Text(now time.Time, width int) string {
+	return now.Format(cw.Layout)
+}
+
+func (cw *clockWidget) Next(now time.Time, width int) time.Time {
+	return now.Truncate(time.Second).Add(time.Second)
+}
+
+// timerWidget shows the elapsed and/or remaining time of a TimeSpan.
+type timerWidget struct {
+	Span   TimeSpan
+	Format string
+}
+
+// formatSpan formats `tm` as "mm:ss", or as "hh:mm:ss" if it is long enough.
+func formatSpan(tm time.Duration) string {
+	h, m, s := int(tm.Hours()), int(tm.Minutes())%60, int(tm.Seconds())%60
+
+	f := fmt.Sprintf("%02d:%02d", m, s)
+	if h == 0 {
+		return f
+	}
+
+	return fmt.Sprintf("%02d:", h) + f
+}
+
+func (tw *timerWidget) Text(now time.Time, width int) string {
+	elapsed := tw.Span.elapsed(now)
+
+	return strings.NewReplacer(
+		"%e", formatSpan(elapsed),
+		"%r", formatSpan(tw.Span.Duration-elapsed),
+		"%t", formatSpan(tw.Span.Duration),
+		"%%", "%",
+	).Replace(tw.Format)
+}
+
+func (tw *timerWidget) Next(now time.Time, width int) time.Time {
+	return tw.Span.next(now, time.Second)
+}
+
+// progressWidget fills the line with Bar according to a TimeSpan.
+// The rest of the line is padded like any other text (see the fill attribute).
+type progressWidget struct {
+	Span TimeSpan
+	Bar  rune
+}
+
+// step returns how long it takes to fill one more cell of the line.
+func (pw *progressWidget) step(width int) time.Duration {
+	if width <= 0 {
+		return 0
+	}
+
+	return pw.Span.Duration / time.Duration(width)
+}
+
+func (pw *progressWidget) Text(now time.Time, width int) string {
+	step := pw.step(width)
+	if step <= 0 {
+		return ""
+	}
+
+	cells := int(pw.Span.elapsed(now) / step)
+	if cells > width {
+		cells = width
+	}
+
+	return strings.Repeat(string(pw.Bar), cells)
+}
+
+func (pw *progressWidget) Next(now time.Time, width int) time.Time {
+	return pw.Span.next(now, pw.step(width))
+}
+
+// parseWidget parses the spec of a widget of `kind` declared at `now`:
+//
+//	clock    [<layout>]
+//	timer    <elapsed> <duration> <running|paused> [<format>]
+//	progress <elapsed> <duration> <running|paused> [<bar char>]
+//	none
+//
+// The clock layout is a Go time layout; DefaultClockLayout if empty.
+// The timer format may contain %e (elapsed), %r (remaining), %t (total)
+// and %% (a literal %); DefaultTimerFormat if empty.
+// A nil widget is returned for "none".
+func parseWidget(kind, spec string, now time.Time) (Widget, error) {
+	switch kind {
+	case "clock":
+		if spec == "" {
+			spec = DefaultClockLayout
+		}
+
+		return &clockWidget{Layout: spec}, nil
+	case "timer", "progress":
+		fields := strings.SplitN(spec, " ", 4)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("Usage: %s <elapsed> <duration> <%s|%s> [...]", kind, SpanRunning, SpanPaused)
+		}
+
+		span, err := ParseTimeSpan(fields[:3], now)
+		if err != nil {
+			return nil, err
+		}
+
+		extra := ""
+		if len(fields) == 4 {
+			extra = fields[3]
+		}
+
+		if kind == "timer" {
+			if extra == "" {
+				extra = DefaultTimerFormat
+			}
+
+			return &timerWidget{Span: span, Format: extra}, nil
+		}
+
+		bar := []rune(extra)
+		switch len(bar) {
+		case 0:
+			bar = []rune{'━'}
+		case 1:
+		default:
+			return nil, fmt.Errorf("Bad bar char `%s` (exactly one expected)", extra)
+		}
+
+		return &progressWidget{Span: span, Bar: bar[0]}, nil
+	case "none":
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("Unknown widget `%s` (clock, timer, progress or none)", kind)
+}
@@ -28,17 +28,30 @@ type Window struct {
 	// Height is the number of lines that can be shown simultaneously.
 	Height int
 
-	// UseEncoding defines if a special LCD encoding shall be used.
-	UseEncoding bool
+	// Encoder converts the text to the character set of the display.
+	Encoder Encoder
+
+	// Glyphs maps characters to the programmable LCD slots.
+	Glyphs map[rune]rune
+
+	// Owner is the id of the connection that created the window.
+	// It is 0 if the window has no (connected) owner.
+	Owner int
+
+	// frozen is returned by Render while a batch is open.
+	frozen [][]rune
+
+	// batchDepth is the number of currently open batches.
+	batchDepth int
 }
 
 // NewWindow returns a new window with the dimensions `w`x`h`, named by `name`.
-func NewWindow(name string, w, h int, useEncoding bool) *Window {
+func NewWindow(name string, w, h int, enc Encoder) *Window {
 	win := &Window{
-		Name:        name,
-		Width:       w,
-		Height:      h,
-		UseEncoding: useEncoding,
+		Name:    name,
+		Width:   w,
+		Height:  h,
+		Encoder: enc,
 	}
 
 	for i := 0; i < h; i++ {
@@ -50,16 +63,62 @@ func NewWindow(name string, w, h int, useEncoding bool) *Window {
 	return win
 }
 
-// SetLine sets text of line `pos` to `text`.
+// SetLine sets text of line `pos` to `text`, replacing any widget.
 // If the line does not exist yet it will be created.
 func (win *Window) SetLine(pos int, text string) error {
+	line, err := win.line(pos)
+	if err != nil {
+		return err
+	}
+
+	line.SetWidget(nil, time.Time{})
+	line.SetText(text, win.Encoder, win.Glyphs)
+	return nil
+}
+
+// SetWidget makes `widget` generate the text of line `pos`.
+// A nil `widget` stops that, but keeps the last generated text.
+// If the line does not exist yet it will be created.
+func (win *Window) SetWidget(pos int, widget Widget, now time.Time) error {
+	line, err := win.line(pos)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the line knows how to encode the widget's text:
+	line.SetText(line.Text(), win.Encoder, win.Glyphs)
+	line.SetWidget(widget, now)
+	return nil
+}
+
+// SetAttrs sets the attributes `attrs` (key and value) of line `pos`.
+// If the line does not exist yet it will be created.
+func (win *Window) SetAttrs(pos int, attrs [][2]string) error {
+	line, err := win.line(pos)
+	if err != nil {
+		return err
+	}
+
+	for _, attr := range attrs {
+		if err := line.SetAttr(attr[0], attr[1]); err != nil {
+			return err
+		}
+	}
+
+	// Make sure a new fill character gets encoded:
+	line.SetText(line.Text(), win.Encoder, win.Glyphs)
+	return nil
+}
+
+// line returns the line at `pos`, creating it and all lines before if needed.
+func (win *Window) line(pos int) (*Line, error) {
 	if pos < 0 {
-		return fmt.Errorf("Bad line position %d", pos)
+		return nil, fmt.Errorf("Bad line position %d", pos)
 	}
 
 	// For safety:
 	if pos > 1024 {
-		return fmt.Errorf("Only up to 1024 lines supported.")
+		return nil, fmt.Errorf("Only up to 1024 lines supported.")
 	}
 
 	// We need to extend:
@@ -76,8 +135,7 @@ func (win *Window) SetLine(pos int, text string) error {
 	}
 
 	win.NLines = len(win.Lines)
-	win.Lines[pos].SetText(text, win.UseEncoding)
-	return nil
+	return win.Lines[pos], nil
 }
 
 // SetScrollDelay sets the scroll shift delay of line `pos` to `delay`.
@@ -135,12 +193,22 @@ func (win *Window) Truncate(n int) int {
 
 	// Clear remaining lines:
 	for i := nlines; i < win.NLines; i++ {
-		win.Lines[i].SetText("", win.UseEncoding)
+		win.Lines[i].SetWidget(nil, time.Time{})
+		win.Lines[i].SetText("", win.Encoder, win.Glyphs)
 	}
 
 	return nlines
 }
 
+// SetGlyphs changes the mapping of characters to programmable LCD slots
+// and re-encodes all lines with it.
+func (win *Window) SetGlyphs(glyphs map[rune]rune) {
+	win.Glyphs = glyphs
+	for _, line := range win.Lines {
+		line.SetText(line.Text(), win.Encoder, glyphs)
+	}
+}
+
 // Switch makes `win` to the active window.
 func (win *Window) Switch() {
 	for _, line := range win.Lines {
@@ -148,16 +216,76 @@ func (win *Window) Switch() {
 	}
 }
 
+// Begin opens a batch: Until the matching Commit, Render will return the
+// contents as they were on Begin. Batches may be nested.
+func (win *Window) Begin() {
+	if win.batchDepth == 0 {
+		win.frozen = win.render()
+	}
+
+	win.batchDepth++
+}
+
+// Commit closes a batch opened by Begin. When the last batch is closed,
+// all changes made in between become visible at once.
+func (win *Window) Commit() error {
+	if win.batchDepth == 0 {
+		return fmt.Errorf("No batch open on window `%s`", win.Name)
+	}
+
+	win.batchDepth--
+	if win.batchDepth == 0 {
+		win.frozen = nil
+	}
+
+	return nil
+}
+
 // Render returns the whole current LCD matrix as bytes.
+// While a batch is open, the contents at the time of Begin are returned.
 func (win *Window) Render() [][]rune {
+	if win.frozen != nil {
+		return win.frozen
+	}
+
+	return win.render()
+}
+
+func (win *Window) visibleLines() []*Line {
 	hi := win.LineOffset + win.Height
 	if hi > win.NLines {
 		hi = win.NLines
 	}
 
+	return win.Lines[win.LineOffset:hi]
+}
+
+// Advance shifts all visible lines that are scrolling and due at `now`
+// and renders their widgets. It returns true if something changed and the
+// time when the next update is needed (zero if no visible line needs one).
+func (win *Window) Advance(now time.Time) (bool, time.Time) {
+	changed, next := false, time.Time{}
+
+	for _, line := range win.visibleLines() {
+		if line.Advance(now) {
+			changed = true
+		}
+
+		if lineNext := line.NextShift(); !lineNext.IsZero() {
+			if next.IsZero() || lineNext.Before(next) {
+				next = lineNext
+			}
+		}
+	}
+
+	return changed, next
+}
+
+func (win *Window) render() [][]rune {
 	out := [][]rune{}
-	for _, line := range win.Lines[win.LineOffset:hi] {
-		out = append(out, line.Render())
+	for _, line := range win.visibleLines() {
+		// Copy, since the line buffer might change later:
+		out = append(out, append([]rune{}, line.Render()...))
 	}
 
 	return out
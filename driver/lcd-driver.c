@@ -7,6 +7,8 @@
 #include <wiringPi.h>
 #include <lcd.h>
 
+#include "softPwm.h"
+
 #define LCD_RS 7
 #define LCD_E  8
 #define LCD_D4 25
@@ -17,6 +19,12 @@
 #define LCD_WIDTH 20
 #define LCD_HEIGHT 4
 
+// PWM pins for the backlight LED and the contrast voltage (V0).
+// Levels are given in percent by displayd.
+#define LCD_BACKLIGHT 12
+#define LCD_CONTRAST  13
+#define LCD_MAX_LEVEL 100
+
 // Seems to need an extra prototype to silence a warning...
 // (actual implementation is in wiringPiDev)
 extern void lcdCharDef(const int fd, int index, unsigned char data [8]);
@@ -125,8 +133,67 @@ static uint8_t GlyphDataCactus[8] = {
 };
 
 
+// Parse a level of "!backlight <level>" or "!contrast <level>"; -1 if bad.
+static int parse_level(char *spec) {
+    char *end = NULL;
+    int level = strtol(spec, &end, 10);
+    if(end == spec || *end != 0) {
+        return -1;
+    }
+
+    if(level < 0 || level > LCD_MAX_LEVEL) {
+        return -1;
+    }
+
+    return level;
+}
+
+// Handle lines that do not contain text:
+// "!glyph <slot> <8 comma separated hex rows>"
+// "!backlight <level>" and "!contrast <level>" (0-100)
+static void handle_control(int handle, char *line) {
+    if(strncmp(line, "!backlight ", 11) == 0) {
+        int level = parse_level(line + 11);
+        if(level >= 0) {
+            softPwmWrite(LCD_BACKLIGHT, level);
+        }
+        return;
+    }
+
+    if(strncmp(line, "!contrast ", 10) == 0) {
+        int level = parse_level(line + 10);
+        if(level >= 0) {
+            // More voltage on V0 means less contrast:
+            softPwmWrite(LCD_CONTRAST, LCD_MAX_LEVEL - level);
+        }
+        return;
+    }
+
+    if(strncmp(line, "!glyph ", 7) != 0) {
+        return;
+    }
+
+    char *end = NULL;
+    int slot = strtol(line + 7, &end, 10);
+    if(end == line + 7 || *end != ' ' || slot < 0 || slot > GLYPH_CACTUS) {
+        return;
+    }
+
+    unsigned char data[8];
+    for(int i = 0; i < 8; i++) {
+        char *row = end + 1;
+        data[i] = strtol(row, &end, 16) & 0x1f;
+        if(end == row || *end != (i < 7 ? ',' : 0)) {
+            return;
+        }
+    }
+
+    lcdCharDef(handle, slot, data);
+}
+
 static int read_from_stdin(int handle) {
-    const int n = LCD_WIDTH + 4 + 1;
+    // Enough for text lines and glyph definitions:
+    const int n = LCD_WIDTH + 64;
 
     char matrix[LCD_HEIGHT][LCD_WIDTH];
     for(int y = 0; y < LCD_HEIGHT; y++) {
@@ -155,26 +222,29 @@ static int read_from_stdin(int handle) {
             *newline = 0;
         }
 
-        int lineno = 0;
+        if(*line == '!') {
+            handle_control(handle, line);
+            continue;
+        }
+
+        // Format is "<lineno>[,<offset>] <text>":
+        if(*line < '0' || *line > '9') {
+            // Some bad formatting going on...
+            continue;
+        }
+
+        char *end = NULL;
+        int lineno = strtol(line, &end, 10);
         int offset = 0;
         bool offset_given = false;
 
-        switch(first_space - line) {
-            case 1:
-                // Just a line-number (this driver only supports 0-9 lines in theory).
-                lineno = *line - '0';
-                break;
-            case 3:
-                offset = 10 * (line[1] - '0') + (line[2] - '0');  
-                offset_given = true;
-                break;
-            case 2:
-                offset = line[1] - '0';  
-                offset_given = true;
-                break;
-            default:
-                // Some bad formatting going on...
-                continue;
+        if(*end == ',') {
+            offset = strtol(end + 1, &end, 10);
+            offset_given = true;
+        }
+
+        if(end != first_space || offset < 0 || offset >= LCD_WIDTH) {
+            continue;
         }
 
         if(lineno >= LCD_HEIGHT) {
@@ -270,6 +340,10 @@ int main(int argc, char **argv) {
     lcdHome(handle);
     lcdClear(handle);
 
+    // Full brightness and medium contrast until displayd says otherwise:
+    softPwmCreate(LCD_BACKLIGHT, LCD_MAX_LEVEL, LCD_MAX_LEVEL);
+    softPwmCreate(LCD_CONTRAST, LCD_MAX_LEVEL / 2, LCD_MAX_LEVEL);
+
     // Custom glyph definitions:
     lcdCharDef(handle, GLYPH_HBAR,   GlyphDataHBar);
     lcdCharDef(handle, GLYPH_PLAY,   GlyphDataPlay);
@@ -0,0 +1,145 @@
+package lightd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/studentkittens/eulenfunk/util"
+)
+
+// Calibration adapts colors to the LED strip before they reach the driver,
+// so {255,255,255} looks white and not blinding. It is used by lightd and
+// ambilight alike. A nil *Calibration changes nothing.
+type Calibration struct {
+	// Gamma of the red, green and blue channel; 1 changes nothing.
+	Gamma [3]float64
+
+	// WhiteBalance scales the red, green and blue channel (0-1).
+	WhiteBalance [3]float64
+
+	// MaxBrightness limits the brightness of all colors (0-1).
+	MaxBrightness float64
+
+	// NightBrightness limits the brightness during Night (0-1).
+	// Night is nil if there is no extra limit at night.
+	Night           *util.NightSchedule
+	NightBrightness float64
+}
+
+// parseChannels parses "<r>,<g>,<b>" or a single value for all channels.
+func parseChannels(name, spec string) ([3]float64, error) {
+	values := [3]float64{}
+
+	split := strings.Split(spec, ",")
+	if len(split) != 1 && len(split) != 3 {
+		return values, fmt.Errorf("Bad %s `%s` (<all> or <r>,<g>,<b> expected)", name, spec)
+	}
+
+	for idx := range values {
+		str := strings.TrimSpace(split[idx%len(split)])
+		value, err := strconv.ParseFloat(str, 64)
+		if err != nil || value < 0 {
+			return values, fmt.Errorf("Bad %s value `%s`", name, str)
+		}
+
+		values[idx] = value
+	}
+
+	return values, nil
+}
+
+func checkBrightness(name string, value float64) error {
+	if value < 0 || value > 1 {
+		return fmt.Errorf("%s must be in 0-1 (not %g)", name, value)
+	}
+
+	return nil
+}
+
+// ParseCalibration builds a Calibration from the user given specs.
+// `gamma` and `whiteBalance` are "<r>,<g>,<b>" or one value for all channels;
+// `night` is a schedule like "22:00-07:00" or empty.
+func ParseCalibration(gamma, whiteBalance string, maxBrightness float64, night string, nightBrightness float64) (*Calibration, error) {
+	gammas, err := parseChannels("gamma", gamma)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range gammas {
+		if value == 0 {
+			return nil, fmt.Errorf("Gamma must not be 0")
+		}
+	}
+
+	balance, err := parseChannels("white balance", whiteBalance)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range balance {
+		if err := checkBrightness("White balance", value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkBrightness("Max brightness", maxBrightness); err != nil {
+		return nil, err
+	}
+
+	if err := checkBrightness("Night brightness", nightBrightness); err != nil {
+		return nil, err
+	}
+
+	schedule, err := util.ParseNightSchedule(night)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Calibration{
+		Gamma:           gammas,
+		WhiteBalance:    balance,
+		MaxBrightness:   maxBrightness,
+		Night:           schedule,
+		NightBrightness: nightBrightness,
+	}, nil
+}
+
+// Apply returns the color to send to the driver for `r`, `g` and `b` at `now`.
+func (cal *Calibration) Apply(r, g, b uint8, now time.Time) (uint8, uint8, uint8) {
+	if cal == nil {
+		return r, g, b
+	}
+
+	limit := cal.MaxBrightness
+	if cal.Night != nil && cal.Night.Contains(now) {
+		limit = math.Min(limit, cal.NightBrightness)
+	}
+
+	channels := [3]uint8{r, g, b}
+	for idx, value := range channels {
+		v := math.Pow(float64(value)/255, cal.Gamma[idx])
+		v *= cal.WhiteBalance[idx] * limit
+		channels[idx] = uint8(math.Min(v, 1)*255 + 0.5)
+	}
+
+	return channels[0], channels[1], channels[2]
+}
+
+// NextChange returns when the night limit starts or ends next after `now`,
+// so colors need to be calibrated again. It is zero if there is no night.
+func (cal *Calibration) NextChange(now time.Time) time.Time {
+	if cal == nil || cal.Night == nil {
+		return time.Time{}
+	}
+
+	return cal.Night.Next(now)
+}
+
+// Line returns the calibrated driver line for `r`, `g` and `b`.
+func (cal *Calibration) Line(r, g, b uint8) string {
+	r, g, b = cal.Apply(r, g, b, time.Now())
+	return fmt.Sprintf("%d %d %d\n", r, g, b)
+}
@@ -0,0 +1,116 @@
+package lightd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCalibration(t *testing.T) {
+	tcs := []struct {
+		gamma, balance     string
+		max                float64
+		night              string
+		nightMax           float64
+		ok                 bool
+		wantGamma, wantBal [3]float64
+	}{
+		{"1", "1", 1, "", 0.2, true, [3]float64{1, 1, 1}, [3]float64{1, 1, 1}},
+		{"2.2", "1,0.8,0.6", 0.5, "22:00-07:00", 0.1, true, [3]float64{2.2, 2.2, 2.2}, [3]float64{1, 0.8, 0.6}},
+		{"1, 2 ,3", "0.5", 1, "", 0, true, [3]float64{1, 2, 3}, [3]float64{0.5, 0.5, 0.5}},
+		{"0", "1", 1, "", 0, false, [3]float64{}, [3]float64{}},
+		{"1,2", "1", 1, "", 0, false, [3]float64{}, [3]float64{}},
+		{"-1", "1", 1, "", 0, false, [3]float64{}, [3]float64{}},
+		{"x", "1", 1, "", 0, false, [3]float64{}, [3]float64{}},
+		{"1", "1.5", 1, "", 0, false, [3]float64{}, [3]float64{}},
+		{"1", "1", 2, "", 0, false, [3]float64{}, [3]float64{}},
+		{"1", "1", 1, "", -0.1, false, [3]float64{}, [3]float64{}},
+		{"1", "1", 1, "25:00-07:00", 0, false, [3]float64{}, [3]float64{}},
+	}
+
+	for _, tc := range tcs {
+		cal, err := ParseCalibration(tc.gamma, tc.balance, tc.max, tc.night, tc.nightMax)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%+v should not parse", tc)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%+v failed to parse: %v", tc, err)
+			continue
+		}
+
+		if cal.Gamma != tc.wantGamma || cal.WhiteBalance != tc.wantBal {
+			t.Errorf("%+v gave gamma %v and white balance %v", tc, cal.Gamma, cal.WhiteBalance)
+		}
+
+		if (cal.Night != nil) != (tc.night != "") {
+			t.Errorf("%+v has night %v", tc, cal.Night)
+		}
+	}
+}
+
+func TestCalibrationApply(t *testing.T) {
+	day := time.Date(2016, 5, 1, 12, 0, 0, 0, time.Local)
+	night := time.Date(2016, 5, 1, 23, 0, 0, 0, time.Local)
+
+	mustParse := func(gamma, balance string, max float64, schedule string, nightMax float64) *Calibration {
+		cal, err := ParseCalibration(gamma, balance, max, schedule, nightMax)
+		if err != nil {
+			t.Fatalf("Failed to parse calibration: %v", err)
+		}
+
+		return cal
+	}
+
+	tcs := []struct {
+		name string
+		cal  *Calibration
+		in   rgbColor
+		now  time.Time
+		out  rgbColor
+	}{
+		{"nil", nil, rgbColor{1, 128, 255}, day, rgbColor{1, 128, 255}},
+		{"identity", mustParse("1", "1", 1, "", 0), rgbColor{1, 128, 255}, day, rgbColor{1, 128, 255}},
+		{"gamma", mustParse("2", "1", 1, "", 0), rgbColor{0, 128, 255}, day, rgbColor{0, 64, 255}},
+		{"gamma per channel", mustParse("1,2,1", "1", 1, "", 0), rgbColor{128, 128, 128}, day, rgbColor{128, 64, 128}},
+		{"white balance", mustParse("1", "1,0.5,0", 1, "", 0), rgbColor{255, 255, 255}, day, rgbColor{255, 128, 0}},
+		{"max brightness", mustParse("1", "1", 0.5, "", 0), rgbColor{255, 100, 0}, day, rgbColor{128, 50, 0}},
+		{"night by day", mustParse("1", "1", 1, "22:00-07:00", 0.2), rgbColor{255, 255, 255}, day, rgbColor{255, 255, 255}},
+		{"night", mustParse("1", "1", 1, "22:00-07:00", 0.2), rgbColor{255, 255, 255}, night, rgbColor{51, 51, 51}},
+		{"lower max at night", mustParse("1", "1", 0.1, "22:00-07:00", 0.2), rgbColor{255, 0, 0}, night, rgbColor{26, 0, 0}},
+	}
+
+	for _, tc := range tcs {
+		r, g, b := tc.cal.Apply(tc.in.R, tc.in.G, tc.in.B, tc.now)
+		if out := (rgbColor{r, g, b}); out != tc.out {
+			t.Errorf("%s: %v gave %v (want %v)", tc.name, tc.in, out, tc.out)
+		}
+	}
+}
+
+func TestCalibrationNextChange(t *testing.T) {
+	day := time.Date(2016, 5, 1, 12, 0, 0, 0, time.Local)
+
+	var nilCal *Calibration
+	if next := nilCal.NextChange(day); !next.IsZero() {
+		t.Errorf("Nil calibration changes at %v", next)
+	}
+
+	cal, err := ParseCalibration("1", "1", 1, "22:00-07:00", 0.2)
+	if err != nil {
+		t.Fatalf("Failed to parse calibration: %v", err)
+	}
+
+	want := time.Date(2016, 5, 1, 22, 0, 0, 0, time.Local)
+	if next := cal.NextChange(day); !next.Equal(want) {
+		t.Errorf("Night starts at %v (want %v)", next, want)
+	}
+
+	want = time.Date(2016, 5, 2, 7, 0, 0, 0, time.Local)
+	if next := cal.NextChange(want.Add(-time.Hour)); !next.Equal(want) {
+		t.Errorf("Night ends at %v (want %v)", next, want)
+	}
+}
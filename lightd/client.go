@@ -1,21 +1,50 @@
 package lightd
 
 import (
+	"bufio"
 	"fmt"
-	"io"
 	"log"
 	"net"
+	"strings"
 
 	"github.com/studentkittens/eulenfunk/util"
 )
 
+// setup sends cfg.Token and cfg.Priority to lightd, if needed.
+func setup(conn net.Conn, cfg *Config) error {
+	if cfg.Token != "" {
+		if _, err := conn.Write([]byte("!auth " + cfg.Token + "\n")); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Priority != PriorityNormal {
+		if _, err := fmt.Fprintf(conn, "!priority %d\n", cfg.Priority); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// effectLine returns the protocol line for `effectSpec`.
+// A plain word that is no color name is taken as name of a preset.
+func effectLine(effectSpec string) string {
+	if !strings.ContainsAny(effectSpec, "{!"+PresetPrefix) && !isColor(effectSpec) {
+		return PresetPrefix + effectSpec
+	}
+
+	return effectSpec
+}
+
 // Send one or more effects to lightd.
+// Presets can be given as "@<name>" or just "<name>".
 func Send(cfg *Config, effects ...string) error {
 	if len(effects) == 0 {
 		return nil
 	}
 
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", cfg.Host, cfg.Port))
+	conn, err := util.Dial(cfg.Host, cfg.Port)
 	if err != nil {
 		log.Printf("Unable to connect to `lightd`: %v", err)
 		return err
@@ -23,9 +52,12 @@ func Send(cfg *Config, effects ...string) error {
 
 	defer util.Closer(conn)
 
+	if err := setup(conn, cfg); err != nil {
+		return err
+	}
+
 	for _, effectSpec := range effects {
-		effectLine := effectSpec + "\n"
-		if _, err := conn.Write([]byte(effectLine)); err != nil {
+		if _, err := conn.Write([]byte(effectLine(effectSpec) + "\n")); err != nil {
 			log.Printf("Cannot send effect `%s`: %v", effectSpec, err)
 			return err
 		}
@@ -34,21 +66,99 @@ func Send(cfg *Config, effects ...string) error {
 	return nil
 }
 
+// replyError returns the error of an "ERR <message>" line of lightd.
+func replyError(line string) error {
+	if strings.HasPrefix(line, "ERR ") {
+		return fmt.Errorf("lightd: %s", strings.TrimPrefix(line, "ERR "))
+	}
+
+	return nil
+}
+
+// query sends `cmd` and returns the lines lightd answers before "OK".
+func query(cfg *Config, cmd string) ([]string, error) {
+	conn, err := util.Dial(cfg.Host, cfg.Port)
+	if err != nil {
+		log.Printf("Unable to connect to `lightd`: %v", err)
+		return nil, err
+	}
+
+	defer util.Closer(conn)
+
+	if err := setup(conn, cfg); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write([]byte(cmd + "\n")); err != nil {
+		return nil, err
+	}
+
+	lines := []string{}
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if scanner.Text() == "OK" {
+			return lines, nil
+		}
+
+		if err := replyError(scanner.Text()); err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("lightd closed the connection before answering `%s`", cmd)
+}
+
+// Presets returns the presets known to lightd as "<name> <effect>" lines.
+func Presets(cfg *Config) ([]string, error) {
+	return query(cfg, "!list")
+}
+
+// Health returns the health of the driver of lightd, e.g. "up" or "up(3)".
+func Health(cfg *Config) (string, error) {
+	lines, err := query(cfg, "!health")
+	if err != nil {
+		return "", err
+	}
+
+	if len(lines) != 1 {
+		return "", fmt.Errorf("Bad health response from lightd: %v", lines)
+	}
+
+	return lines[0], nil
+}
+
+// Stop aborts the effect lightd is currently playing.
+func Stop(cfg *Config) error {
+	return Send(cfg, "!stop")
+}
+
 // Locker is a utility to hold a lock on the LED resource
 type Locker struct {
-	conn net.Conn
+	conn   net.Conn
+	reader *bufio.Reader
 }
 
 // NewLocker will create a new Locker connected to the lightd at `cfg.Host` and
 // `cfg.Port`.
 func NewLocker(cfg *Config) (*Locker, error) {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", cfg.Host, cfg.Port))
+	conn, err := util.Dial(cfg.Host, cfg.Port)
 	if err != nil {
 		log.Printf("Unable to connect to `lightd`: %v", err)
 		return nil, err
 	}
 
-	return &Locker{conn}, nil
+	if err := setup(conn, cfg); err != nil {
+		util.Closer(conn)
+		return nil, err
+	}
+
+	return &Locker{conn, bufio.NewReader(conn)}, nil
 }
 
 // Lock will give you exclusive access to the LED or waits until it can be locked.
@@ -66,12 +176,12 @@ func (lk *Locker) send(msg string) error {
 		return err
 	}
 
-	ok := make([]byte, 3)
-	if _, err := lk.conn.Read(ok); err != nil && err != io.EOF {
+	reply, err := lk.reader.ReadString('\n')
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return replyError(strings.TrimSpace(reply))
 }
 
 // Close will close the connection used by Locker
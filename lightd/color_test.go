@@ -0,0 +1,94 @@
+package lightd
+
+import "testing"
+
+func TestParseColor(t *testing.T) {
+	tcs := []struct {
+		spec  string
+		ok    bool
+		color rgbColor
+	}{
+		{"{255,0,128}", true, rgbColor{255, 0, 128}},
+		{"c{1,2,3}", true, rgbColor{1, 2, 3}},
+		{"color{1,2,3}", true, rgbColor{1, 2, 3}},
+		{"{256,0,0}", false, rgbColor{}},
+		{"{1,2}", false, rgbColor{}},
+		{"#ff8800", true, rgbColor{255, 136, 0}},
+		{"#FF8800", true, rgbColor{255, 136, 0}},
+		{"#f80", true, rgbColor{255, 136, 0}},
+		{"#ggg", false, rgbColor{}},
+		{"#ff880", false, rgbColor{}},
+		{"orange", true, rgbColor{255, 165, 0}},
+		{"Red", true, rgbColor{255, 0, 0}},
+		{"rebeccapurple", true, rgbColor{102, 51, 153}},
+		{"nocolor", false, rgbColor{}},
+		{"hsv{0,1,1}", true, rgbColor{255, 0, 0}},
+		{"hsv{120,1,1}", true, rgbColor{0, 255, 0}},
+		{"hsv{240,1,0.5}", true, rgbColor{0, 0, 128}},
+		{"hsv{360,1,1}", true, rgbColor{255, 0, 0}},
+		{"hsv{30,0,1}", true, rgbColor{255, 255, 255}},
+		{"hsv{-1,1,1}", false, rgbColor{}},
+		{"hsv{361,1,1}", false, rgbColor{}},
+		{"hsv{NaN,1,1}", false, rgbColor{}},
+		{"hsv{30,2,1}", false, rgbColor{}},
+		{"hsv{30,1}", false, rgbColor{}},
+		{"hsv{a,b,c}", false, rgbColor{}},
+		{"hcl{0,0,0}", true, rgbColor{0, 0, 0}},
+		{"hcl{0,0,1}", true, rgbColor{255, 255, 255}},
+		{"hcl{-10,0.5,0.5}", false, rgbColor{}},
+		{"hcl{400,0.5,0.5}", false, rgbColor{}},
+	}
+
+	for _, tc := range tcs {
+		color, err := parseColor(tc.spec)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("`%s` should not parse, but gave %v", tc.spec, *color)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("`%s` failed to parse: %v", tc.spec, err)
+			continue
+		}
+
+		if *color != tc.color {
+			t.Errorf("`%s` gave %v (want %v)", tc.spec, *color, tc.color)
+		}
+	}
+}
+
+func TestHclHueWraps(t *testing.T) {
+	zero, err := parseColor("hcl{0,0.5,0.6}")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	full, err := parseColor("hcl{360,0.5,0.6}")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if *zero != *full {
+		t.Errorf("Hue 0 gave %v, but 360 gave %v", *zero, *full)
+	}
+}
+
+func TestIsColor(t *testing.T) {
+	tcs := map[string]bool{
+		"red":        true,
+		"DarkOrange": true,
+		"#123":       true,
+		"poweroff":   false,
+		"{1,2,3}":    false,
+		"seq{red}":   false,
+	}
+
+	for s, want := range tcs {
+		if isColor(s) != want {
+			t.Errorf("isColor(`%s`) is %v", s, !want)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package lightd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// minLoopPeriod paces loops whose body takes no time (like loop{-1|{255,0,0}}),
+// so they do not eat all cpu time.
+const minLoopPeriod = 20 * time.Millisecond
+
+// Play several effects one after another
+type seqEffect struct {
+	Effects []effect
+}
+
+// Repeat an effect; a negative Count repeats it forever
+type loopEffect struct {
+	Count  int
+	Effect effect
+}
+
+// Play several effects at the same time; the brightest value of each
+// channel wins
+type mixEffect struct {
+	Effects []effect
+}
+
+// Keep the current color for a while
+type waitEffect struct {
+	Duration time.Duration
+}
+
+// forward sends all colors of `src` on `c`.
+// It returns false if `ctx` got cancelled in between.
+func forward(ctx context.Context, c chan<- rgbColor, src chan rgbColor) bool {
+	for color := range src {
+		if !emit(ctx, c, color) {
+			return false
+		}
+	}
+
+	return ctx.Err() == nil
+}
+
+func anyEndless(effects []effect) bool {
+	for _, effect := range effects {
+		if effect.Endless() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Endless is true if one of the effects is.
+func (effect *seqEffect) Endless() bool {
+	return anyEndless(effect.Effects)
+}
+
+// Endless is true for negative counts or an endless body.
+func (effect *loopEffect) Endless() bool {
+	return effect.Count < 0 || effect.Effect.Endless()
+}
+
+// Endless is true if one of the effects is.
+func (effect *mixEffect) Endless() bool {
+	return anyEndless(effect.Effects)
+}
+
+// Endless is false; waiting has a fixed duration.
+func (effect *waitEffect) Endless() bool {
+	return false
+}
+
+func (effect *seqEffect) ComposeEffect(ctx context.Context) chan rgbColor {
+	c := make(chan rgbColor, 1)
+	go func() {
+		defer close(c)
+
+		for _, sub := range effect.Effects {
+			if !forward(ctx, c, sub.ComposeEffect(ctx)) {
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+func (effect *loopEffect) ComposeEffect(ctx context.Context) chan rgbColor {
+	c := make(chan rgbColor, 1)
+	go func() {
+		defer close(c)
+
+		for n := effect.Count; n > 0 || effect.Count < 0; n-- {
+			start := time.Now()
+			if !forward(ctx, c, effect.Effect.ComposeEffect(ctx)) {
+				return
+			}
+
+			if took := time.Since(start); took < minLoopPeriod {
+				if !pause(ctx, minLoopPeriod-took) {
+					return
+				}
+			}
+		}
+	}()
+
+	return c
+}
+
+func (effect *mixEffect) ComposeEffect(ctx context.Context) chan rgbColor {
+	type update struct {
+		idx   int
+		color rgbColor
+	}
+
+	updates := make(chan update)
+	wg := &sync.WaitGroup{}
+
+	for idx, sub := range effect.Effects {
+		wg.Add(1)
+		go func(idx int, src chan rgbColor) {
+			defer wg.Done()
+
+			for color := range src {
+				select {
+				case updates <- update{idx, color}:
+				case <-ctx.Done():
+				}
+			}
+		}(idx, sub.ComposeEffect(ctx))
+	}
+
+	go func() {
+		wg.Wait()
+		close(updates)
+	}()
+
+	c := make(chan rgbColor, 1)
+	go func() {
+		defer close(c)
+
+		latest := make([]rgbColor, len(effect.Effects))
+		for up := range updates {
+			latest[up.idx] = up.color
+
+			mixed := rgbColor{}
+			for _, color := range latest {
+				mixed.R = max(mixed.R, color.R, 0)
+				mixed.G = max(mixed.G, color.G, 0)
+				mixed.B = max(mixed.B, color.B, 0)
+			}
+
+			if !emit(ctx, c, mixed) {
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+func (effect *waitEffect) ComposeEffect(ctx context.Context) chan rgbColor {
+	c := make(chan rgbColor)
+	go func() {
+		defer close(c)
+		pause(ctx, effect.Duration)
+	}()
+
+	return c
+}
+
+////////////// COMPOSITION PARSING //////////////////
+
+// unwrapBody returns what is between the braces of `s` ("{...}").
+func unwrapBody(s string) (string, error) {
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return "", fmt.Errorf("Missing braces: `%s`", s)
+	}
+
+	body := s[1 : len(s)-1]
+	if _, err := splitTopLevel(body, 0); err != nil {
+		return "", err
+	}
+
+	return body, nil
+}
+
+// splitTopLevel splits `s` at every `sep` that is not nested in braces.
+// A `sep` of 0 only checks if the braces are balanced.
+func splitTopLevel(s string, sep byte) ([]string, error) {
+	parts := []string{}
+	depth, last := 0, 0
+
+	for idx := 0; idx < len(s); idx++ {
+		switch s[idx] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("Unbalanced braces: `%s`", s)
+			}
+		case sep:
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(s[last:idx]))
+				last = idx + 1
+			}
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("Unbalanced braces: `%s`", s)
+	}
+
+	return append(parts, strings.TrimSpace(s[last:])), nil
+}
+
+func parseEffectList(s string, lookup presetLookup) ([]effect, error) {
+	body, err := unwrapBody(s)
+	if err != nil {
+		return nil, err
+	}
+
+	specs, err := splitTopLevel(body, ';')
+	if err != nil {
+		return nil, err
+	}
+
+	effects := []effect{}
+	for _, spec := range specs {
+		// Allow a trailing ";":
+		if spec == "" {
+			continue
+		}
+
+		effect, err := parseEffect(spec, lookup)
+		if err != nil {
+			return nil, err
+		}
+
+		effects = append(effects, effect)
+	}
+
+	if len(effects) == 0 {
+		return nil, fmt.Errorf("No effects given: `%s`", s)
+	}
+
+	return effects, nil
+}
+
+func parseSeqEffect(s string, lookup presetLookup) (*seqEffect, error) {
+	effects, err := parseEffectList(s, lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	return &seqEffect{effects}, nil
+}
+
+func parseMixEffect(s string, lookup presetLookup) (*mixEffect, error) {
+	effects, err := parseEffectList(s, lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mixEffect{effects}, nil
+}
+
+func parseLoopEffect(s string, lookup presetLookup) (*loopEffect, error) {
+	body, err := unwrapBody(s)
+	if err != nil {
+		return nil, err
+	}
+
+	sepIdx := strings.Index(body, "|")
+	if sepIdx < 0 {
+		return nil, fmt.Errorf("Bad loop effect: %s", s)
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(body[:sepIdx]))
+	if err != nil {
+		return nil, fmt.Errorf("Bad loop count: `%s`: %v", body[:sepIdx], err)
+	}
+
+	effect, err := parseEffect(strings.TrimSpace(body[sepIdx+1:]), lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loopEffect{count, effect}, nil
+}
+
+func parseWaitEffect(s string) (*waitEffect, error) {
+	body, err := unwrapBody(s)
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := time.ParseDuration(strings.TrimSpace(body))
+	if err != nil {
+		return nil, fmt.Errorf("Bad duration: `%s`: %v", body, err)
+	}
+
+	return &waitEffect{duration}, nil
+}
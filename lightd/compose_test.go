@@ -0,0 +1,190 @@
+package lightd
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// testPresets resolves presets in tests without a running lightd.
+func testPresets(specs map[string]string) presetLookup {
+	return func(name string) (string, error) {
+		spec, ok := specs[name]
+		if !ok {
+			return "", fmt.Errorf("No such preset: `%s`", name)
+		}
+
+		return spec, nil
+	}
+}
+
+// collect returns all colors of `e`; endless effects are cut after `timeout`.
+func collect(e effect, timeout time.Duration) []rgbColor {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	colors := []rgbColor{}
+	for color := range e.ComposeEffect(ctx) {
+		colors = append(colors, color)
+	}
+
+	return colors
+}
+
+func TestParseComposed(t *testing.T) {
+	lookup := testPresets(map[string]string{
+		"blink": "loop{2|seq{red;wait{1ms};black}}",
+		"pulse": "flash{1ms|blue|-1}",
+	})
+
+	tcs := []struct {
+		spec    string
+		ok      bool
+		endless bool
+	}{
+		{"seq{red;blue}", true, false},
+		{"seq{red;}", true, false},
+		{"seq{red;wait{1s};@blink}", true, false},
+		{"seq{red;@pulse}", true, true},
+		{"@pulse", true, true},
+		{"loop{3|seq{{255,0,0};wait{1s};{0,0,0};wait{1s}}}", true, false},
+		{"loop{-1|red}", true, true},
+		{"loop{2|flash{1s|red|-1}}", true, true},
+		{"mix{fade{10ms|red|1};flash{10ms|blue|2}}", true, false},
+		{"mix{red;@pulse}", true, true},
+		{"wait{1s}", true, false},
+		{"seq{}", false, false},
+		{"mix{}", false, false},
+		{"loop{3|}", false, false},
+		{"loop{x|red}", false, false},
+		{"loop{red}", false, false},
+		{"seq{red}}", false, false},
+		{"seq{{red}", false, false},
+		{"seq{red}{blue}", false, false},
+		{"seq{red;@missing}", false, false},
+		{"wait{soon}", false, false},
+		{"foo", false, false},
+		{"foo{1}", false, false},
+	}
+
+	for _, tc := range tcs {
+		e, err := parseEffect(tc.spec, lookup)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("`%s` should not parse", tc.spec)
+			}
+
+			// A typed nil pointer would make e != nil:
+			if e != nil {
+				t.Errorf("`%s` returned an effect with the error: %#v", tc.spec, e)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("`%s` failed to parse: %v", tc.spec, err)
+			continue
+		}
+
+		if e.Endless() != tc.endless {
+			t.Errorf("`%s` is endless: %v (want %v)", tc.spec, e.Endless(), tc.endless)
+		}
+	}
+}
+
+func TestParseNoPresets(t *testing.T) {
+	if e, err := parseEffect("seq{red;@blink}", nil); err == nil || e != nil {
+		t.Errorf("Presets were resolved without lookup: %v %v", e, err)
+	}
+}
+
+func TestSplitTopLevel(t *testing.T) {
+	tcs := []struct {
+		s     string
+		parts []string
+	}{
+		{"a;b;c", []string{"a", "b", "c"}},
+		{"a ; b", []string{"a", "b"}},
+		{"seq{a;b};c", []string{"seq{a;b}", "c"}},
+		{"{{;}};", []string{"{{;}}", ""}},
+		{"a}", nil},
+		{"{a", nil},
+		{"}{", nil},
+	}
+
+	for _, tc := range tcs {
+		parts, err := splitTopLevel(tc.s, ';')
+		if tc.parts == nil {
+			if err == nil {
+				t.Errorf("`%s` has unbalanced braces, but got %v", tc.s, parts)
+			}
+
+			continue
+		}
+
+		if err != nil || !reflect.DeepEqual(parts, tc.parts) {
+			t.Errorf("`%s` was split to %q, %v (want %q)", tc.s, parts, err, tc.parts)
+		}
+	}
+}
+
+func TestComposeColors(t *testing.T) {
+	red, blue, black := rgbColor{255, 0, 0}, rgbColor{0, 0, 255}, rgbColor{0, 0, 0}
+	lookup := testPresets(map[string]string{"blue": "{0,0,255}"})
+
+	tcs := []struct {
+		spec   string
+		colors []rgbColor
+	}{
+		{"seq{red;wait{1ms};@blue}", []rgbColor{red, blue}},
+		{"loop{3|seq{red;black}}", []rgbColor{red, black, red, black, red, black}},
+		{"loop{0|red}", []rgbColor{}},
+		{"wait{1ms}", []rgbColor{}},
+	}
+
+	for _, tc := range tcs {
+		e, err := parseEffect(tc.spec, lookup)
+		if err != nil {
+			t.Fatalf("`%s` failed to parse: %v", tc.spec, err)
+		}
+
+		if colors := collect(e, 5*time.Second); !reflect.DeepEqual(colors, tc.colors) {
+			t.Errorf("`%s` gave %v (want %v)", tc.spec, colors, tc.colors)
+		}
+	}
+}
+
+func TestComposeMix(t *testing.T) {
+	e, err := parseEffect("mix{{10,0,40};{0,20,30}}", nil)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	colors := collect(e, 5*time.Second)
+	if len(colors) == 0 {
+		t.Fatalf("Mix gave no colors")
+	}
+
+	// The brightest value of each channel wins:
+	if last := colors[len(colors)-1]; last != (rgbColor{10, 20, 40}) {
+		t.Errorf("Mix ended with %v", last)
+	}
+}
+
+func TestComposeEndlessStops(t *testing.T) {
+	e, err := parseEffect("loop{-1|red}", nil)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	start := time.Now()
+	collect(e, 50*time.Millisecond)
+
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Endless loop took %s to stop", took)
+	}
+}
@@ -8,30 +8,81 @@
 // lightd can be controlled by a simple line based network protocol,
 // which currently supports the following commands:
 //
-// !lock      -- Try to acquire lock or block until available.
-// !unlock    -- Give back lock.
-// !close     -- Close the connection.
-// <effect>   -- Lines starting without ! are parsed as effect spec.
+// !lock         -- Stop the current effect and keep others from playing
+//                  until !unlock; blocks while an alert plays. Alerts stop
+//                  the lock. The lock stays when the connection is closed.
+// !unlock       -- Give back the lock (may be sent by any connection).
+// !close        -- Close the connection.
+// !auth <t>     -- Authenticate with token <t>.
+// !stop         -- Stop the currently playing effect.
+// !priority <n> -- Use priority <n> for the following effects (default 0).
+// !define <name> <effect>
+//               -- Define (or redefine) the preset <name>.
+// !list         -- Print all presets as "<name> <effect>" lines, then "OK".
+// !health       -- Print the health of the driver ("up", "down" or "up(3)"
+//                  after 3 restarts), then "OK".
+// @<name>       -- Play the preset <name>.
+// <effect>      -- Lines starting without ! are parsed as effect spec.
+//
+// Presets are read on startup from Config.PresetsFile, one
+// "<name> <effect>" per line; #-lines are comments. Presets defined with
+// !define are forgotten on restart. A preset may use presets defined before
+// it, but not itself.
+//
+// Effects of one connection are played one after another. An effect stops
+// a running effect of the same or a lower priority (of any connection) and
+// waits for one with a higher priority. Endless effects (a <repeat> below 0)
+// stop when the connection sending them is closed; all others still play.
+// A connection can queue up to 16 effects; further ones are dropped.
+//
+// Before a color reaches the driver, it is calibrated to the LED strip
+// (see Calibration): gamma per channel, white balance and a brightness
+// limit, which can be lower at night. When the night starts or ends, the
+// last color is sent again with the new limit. ambilight uses the same
+// calibration.
+//
+// If lightd has a control token (Config.ControlToken), everything but
+// !close and !auth is refused until the connection sent that token.
+// !health and !list only need Config.ReadToken (or the control token) if
+// that is set. Refused commands and a wrong token are answered with
+// "ERR <message>".
 //
 // <effect> can be one of the following:
 //
 //   {<r>,<g>,<b>}
-//   blend{<src-color>|<dst-color>|<duration>}
+//   blend{<src-color>|<dst-color>|<duration>[|hcl]}
 //   flash{<duration>|<color>|<repeat>}
 //   fire{<duration>|<color>|<repeat>}
 //   fade{<duration>|<color>|<repeat>}
+//   seq{<effect>;<effect>;...}      -- Play the effects one after another.
+//   loop{<repeat>|<effect>}         -- Play <effect> <repeat> times.
+//   mix{<effect>;<effect>;...}      -- Play the effects at the same time;
+//                                      the brightest channel values win.
+//   wait{<duration>}                -- Keep the current color.
+//   @<name>                         -- The effect of preset <name>.
 //
 // where <*-color> can be:
 //
-//   {<r>,<g>,<b>}
+//   {<r>,<g>,<b>}                   -- Values in 0-255.
+//   #<rrggbb> or #<rgb>             -- Hex notation.
+//   hsv{<h>,<s>,<v>}                -- Hue in degrees (0-360), the rest in 0-1.
+//   hcl{<h>,<c>,<l>}                -- Hue in degrees (0-360), the rest in 0-1.
+//   <name>                          -- A CSS color name like "orange".
+//
+// A color alone is a valid effect as well. blend interpolates in RGB,
+// or in the smoother HCL color space if "hcl" is given.
 //
 // and where <duration> is something time.ParseDuration() understands.
-// <repeat> is a simple integer.
+// <repeat> is a simple integer; a negative one repeats forever.
 //
 // Examples:
 //
 //   {255,0,255}                     -- The world needs more solid pink.
 //   fire{1ms|{255,255,255}|0}       -- Warm fire effect.
 //   blend{{255,0,0}|{0,255,0}|2s}   -- Blend from red to green.
+//   blend{red|#00ff00|2s|hcl}       -- The same, but in HCL.
+//   loop{3|seq{{255,0,0};wait{1s};{0,0,0};wait{1s}}}
+//                                   -- Blink red three times.
+//   seq{red;wait{1s};@poweroff}     -- Red for a second, then a preset.
 //
 package lightd
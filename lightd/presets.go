@@ -0,0 +1,146 @@
+package lightd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/studentkittens/eulenfunk/util"
+)
+
+// PresetPrefix marks an effect line as the name of a preset, e.g. "@poweroff".
+const PresetPrefix = "@"
+
+// DefaultPresets are known even without a presets file.
+var DefaultPresets = map[string]string{
+	"poweroff": "flash{200ms|{255,0,0}|5}",
+	"reboot":   "flash{200ms|{255,150,0}|5}",
+}
+
+// presets maps names to effect specs.
+type presets struct {
+	sync.RWMutex
+	specs map[string]string
+}
+
+func newPresets() *presets {
+	pr := &presets{specs: make(map[string]string)}
+	for name, spec := range DefaultPresets {
+		pr.specs[name] = spec
+	}
+
+	return pr
+}
+
+// Define sets preset `name` to `spec`, if `spec` is a valid effect.
+// Presets may use other presets, but not themselves (not even indirectly).
+func (pr *presets) Define(name, spec string) error {
+	if name == "" || strings.ContainsAny(name, " \t{}") {
+		return fmt.Errorf("Bad preset name: `%s`", name)
+	}
+
+	pr.Lock()
+	defer pr.Unlock()
+
+	// Existing presets have no cycles, so a new one would pass `name`:
+	lookup := func(used string) (string, error) {
+		if used == name {
+			return "", fmt.Errorf("Preset `%s` may not use itself", name)
+		}
+
+		return pr.spec(used)
+	}
+
+	if _, err := parseEffect(spec, lookup); err != nil {
+		return err
+	}
+
+	pr.specs[name] = spec
+	return nil
+}
+
+// Spec returns the effect spec of preset `name`; see presetLookup.
+func (pr *presets) Spec(name string) (string, error) {
+	pr.RLock()
+	defer pr.RUnlock()
+
+	return pr.spec(name)
+}
+
+// spec is Spec for callers that hold the lock already.
+func (pr *presets) spec(name string) (string, error) {
+	spec, ok := pr.specs[name]
+	if !ok {
+		return "", fmt.Errorf("No such preset: `%s`", name)
+	}
+
+	return spec, nil
+}
+
+// List writes all presets as "<name> <spec>" lines, sorted by name.
+func (pr *presets) List(w io.Writer) error {
+	pr.RLock()
+	defer pr.RUnlock()
+
+	names := []string{}
+	for name := range pr.specs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%s %s\n", name, pr.specs[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseDefinition splits "<name> <spec>".
+func parseDefinition(line string) (string, string, error) {
+	split := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	if len(split) < 2 {
+		return "", "", fmt.Errorf("Bad preset definition: `%s` (<name> <spec> expected)", line)
+	}
+
+	return split[0], strings.TrimSpace(split[1]), nil
+}
+
+// Load reads presets from `path`; one "<name> <spec>" per line.
+// Empty lines and lines starting with # are skipped.
+func (pr *presets) Load(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer util.Closer(fd)
+
+	lineNo := 0
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		lineNo++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, spec, err := parseDefinition(line)
+		if err == nil {
+			err = pr.Define(name, spec)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,176 @@
+package lightd
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/studentkittens/eulenfunk/util"
+	"golang.org/x/net/context"
+)
+
+func TestPresetDefine(t *testing.T) {
+	tcs := []struct {
+		name, spec string
+		ok         bool
+	}{
+		{"red", "{255,0,0}", true},
+		{"alarm", "flash{100ms|red|3}", true},
+		{"warn", "seq{@alarm;wait{1s};@poweroff}", true},
+		{"", "red", false},
+		{"two words", "red", false},
+		{"br{ace", "red", false},
+		{"tab\tname", "red", false},
+		{"broken", "flash{100ms|red}", false},
+		{"missing", "seq{red;@nothere}", false},
+		{"self", "seq{red;@self}", false},
+		// warn uses alarm, so alarm may not use warn:
+		{"alarm", "@warn", false},
+	}
+
+	pr := newPresets()
+	for _, tc := range tcs {
+		err := pr.Define(tc.name, tc.spec)
+		if (err == nil) != tc.ok {
+			t.Errorf("Define(`%s`, `%s`) gave %v (want ok: %v)", tc.name, tc.spec, err, tc.ok)
+		}
+	}
+
+	// A failed redefinition keeps the old spec:
+	if spec, err := pr.Spec("alarm"); err != nil || spec != "flash{100ms|red|3}" {
+		t.Errorf("Preset `alarm` changed to `%s` (%v)", spec, err)
+	}
+
+	if _, err := pr.Spec("broken"); err == nil {
+		t.Errorf("Preset `broken` was defined")
+	}
+}
+
+func TestPresetResolve(t *testing.T) {
+	pr := newPresets()
+	if err := pr.Define("twice", "loop{2|@poweroff}"); err != nil {
+		t.Fatalf("Failed to define: %v", err)
+	}
+
+	for _, spec := range []string{"@twice", "seq{@twice;@reboot}"} {
+		if _, err := parseEffect(spec, pr.Spec); err != nil {
+			t.Errorf("Failed to parse `%s`: %v", spec, err)
+		}
+	}
+
+	// Redefining a used preset changes the effects using it:
+	if err := pr.Define("poweroff", "flash{1s|red|-1}"); err != nil {
+		t.Fatalf("Failed to redefine: %v", err)
+	}
+
+	e, err := parseEffect("@twice", pr.Spec)
+	if err != nil || !e.Endless() {
+		t.Errorf("`@twice` does not use the new poweroff: %v", err)
+	}
+}
+
+func TestPresetList(t *testing.T) {
+	pr := newPresets()
+	if err := pr.Define("blue", "{0,0,255}"); err != nil {
+		t.Fatalf("Failed to define: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := pr.List(buf); err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"blue {0,0,255}",
+		"poweroff " + DefaultPresets["poweroff"],
+		"reboot " + DefaultPresets["reboot"],
+	}, "\n") + "\n"
+
+	if buf.String() != want {
+		t.Errorf("Unexpected list:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestPresetLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightd-presets")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	good := filepath.Join(dir, "good.conf")
+	data := "# Comment\n\nalarm flash{100ms|red|3}\nwarn   seq{@alarm;wait{1s}}\n"
+	if err := ioutil.WriteFile(good, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write presets: %v", err)
+	}
+
+	pr := newPresets()
+	if err := pr.Load(good); err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+
+	if spec, err := pr.Spec("warn"); err != nil || spec != "seq{@alarm;wait{1s}}" {
+		t.Errorf("Preset `warn` is `%s` (%v)", spec, err)
+	}
+
+	bad := filepath.Join(dir, "bad.conf")
+	if err := ioutil.WriteFile(bad, []byte("ok red\nnospec\n"), 0644); err != nil {
+		t.Fatalf("Failed to write presets: %v", err)
+	}
+
+	err = newPresets().Load(bad)
+	if err == nil || !strings.Contains(err.Error(), "bad.conf:2:") {
+		t.Errorf("Bad line was not reported with its number: %v", err)
+	}
+}
+
+func TestPresetListCommand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, conn := net.Pipe()
+	defer client.Close()
+
+	// !list needs no token when only a control token is set:
+	access := util.NewAccess(util.Tokens{Control: "secret"})
+	go handleRequest(ctx, conn, &effectQueue{}, newPresets(), access)
+
+	if _, err := client.Write([]byte("!list\n")); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	reader := bufio.NewReader(client)
+	lines := []string{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+
+		if line = strings.TrimSpace(line); line == "OK" {
+			break
+		}
+
+		lines = append(lines, line)
+	}
+
+	if len(lines) != len(DefaultPresets) || !strings.HasPrefix(lines[0], "poweroff ") {
+		t.Errorf("Unexpected !list output: %q", lines)
+	}
+
+	// Defining presets needs the token:
+	if _, err := client.Write([]byte("!define x red\n")); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	reply, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(reply, "ERR ") {
+		t.Errorf("!define without token was answered with `%s` (%v)", reply, err)
+	}
+}
@@ -7,11 +7,11 @@ import (
 	"log"
 	"math"
 	"math/rand"
-	"net"
-	"os/exec"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
@@ -30,36 +30,219 @@ func max(r uint8, g uint8, b uint8) uint8 {
 	return max
 }
 
+// Priorities for effects; see the !priority command.
+const (
+	// PriorityNormal is used by connections that did not ask for another one.
+	PriorityNormal = 0
+	// PriorityAlert is meant for effects that should not be interrupted,
+	// like the shutdown animation.
+	PriorityAlert = 10
+	// PriorityLock is used by !lock; it stops normal effects,
+	// but alerts still stop the lock.
+	PriorityLock = PriorityAlert - 1
+)
+
+// playback is the effect that currently owns the LED.
+type playback struct {
+	prio   int
+	cancel context.CancelFunc
+}
+
 type effectQueue struct {
-	StdInPipe io.Writer
-	Blocked   chan bool
+	sync.Mutex
+	StdInPipe   io.Writer
+	Blocked     chan bool
+	Calibration *Calibration
+
+	// Driver restarts the driver binary; StdInPipe writes to it.
+	Driver *util.Supervisor
+
+	// lastColor is the last color before calibration; it is sent again
+	// when the night limit changes. Protected by colorMu.
+	colorMu   sync.Mutex
+	lastColor rgbColor
+	hasColor  bool
+
+	// The lock taken by !lock; it is not bound to a connection.
+	// Protected by lockMu:
+	lockMu   sync.Mutex
+	lockCtx  context.Context
+	unlockFn context.CancelFunc
+
+	// Protected by the mutex:
+	changed *sync.Cond
+	current *playback
+	waiting map[int]int
 }
 
-func (q *effectQueue) Push(e effect) {
-	c := e.ComposeEffect()
+// acquire waits until an effect with priority `prio` may play and returns
+// the context it should play with. Running effects with the same or a lower
+// priority are stopped; higher ones are waited for. Pass the returned
+// playback to release when done.
+func (q *effectQueue) acquire(ctx context.Context, prio int) (context.Context, *playback, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	// Wake up in case `ctx` gets cancelled while waiting:
+	waitDone := make(chan bool)
+	defer close(waitDone)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			q.Lock()
+			q.changed.Broadcast()
+			q.Unlock()
+		case <-waitDone:
+		}
+	}()
+
+	q.waiting[prio]++
+	defer func() {
+		if q.waiting[prio]--; q.waiting[prio] == 0 {
+			delete(q.waiting, prio)
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		if q.current != nil && q.current.prio <= prio {
+			q.current.cancel()
+		}
+
+		if q.current == nil && q.highestWaiting() <= prio {
+			break
+		}
+
+		q.changed.Wait()
+	}
+
+	playCtx, cancel := context.WithCancel(ctx)
+	q.current = &playback{prio: prio, cancel: cancel}
+	return playCtx, q.current, nil
+}
 
-	for color := range c {
-		colorValue := fmt.Sprintf("%d %d %d\n", color.R, color.G, color.B)
-		if _, err := q.StdInPipe.Write([]byte(colorValue)); err != nil {
-			log.Printf("Failed to write to driver: %v", err)
+func (q *effectQueue) highestWaiting() int {
+	highest := math.MinInt32
+	for prio := range q.waiting {
+		if prio > highest {
+			highest = prio
 		}
 	}
+
+	return highest
+}
+
+func (q *effectQueue) release(pb *playback) {
+	q.Lock()
+	defer q.Unlock()
+
+	pb.cancel()
+	if q.current == pb {
+		q.current = nil
+	}
+
+	q.changed.Broadcast()
+}
+
+// Stop aborts the currently playing effect, if any.
+func (q *effectQueue) Stop() {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.current != nil {
+		q.current.cancel()
+	}
 }
 
-func newEffectQueue(driverBinary string) (*effectQueue, error) {
-	cmd := exec.Command(driverBinary, "cat")
-	stdinpipe, err := cmd.StdinPipe()
+// Push plays `e` with priority `prio` and returns when it is done,
+// got preempted or `ctx` was cancelled.
+func (q *effectQueue) Push(ctx context.Context, e effect, prio int) error {
+	playCtx, pb, err := q.acquire(ctx, prio)
 	if err != nil {
+		return err
+	}
+
+	defer q.release(pb)
+
+	if lock(playCtx, q) {
+		defer unlock(q)
+	}
+
+	for color := range e.ComposeEffect(playCtx) {
+		// Colors that were still buffered when being stopped:
+		if playCtx.Err() != nil {
+			continue
+		}
+
+		q.write(color)
+	}
+
+	return nil
+}
+
+// write sends `color` calibrated to the driver.
+func (q *effectQueue) write(color rgbColor) {
+	q.colorMu.Lock()
+	defer q.colorMu.Unlock()
+
+	q.lastColor, q.hasColor = color, true
+	q.send()
+}
+
+// send writes lastColor with the current calibration; colorMu must be held.
+func (q *effectQueue) send() {
+	color := q.lastColor
+	colorValue := q.Calibration.Line(color.R, color.G, color.B)
+	if _, err := q.StdInPipe.Write([]byte(colorValue)); err != nil {
+		log.Printf("Failed to write to driver: %v", err)
+	}
+}
+
+// recalibrate sends the last color again whenever the night limit starts or
+// ends, so the light changes even if no effect is playing.
+// It returns when `ctx` is cancelled or there is no night.
+func (q *effectQueue) recalibrate(ctx context.Context) {
+	for {
+		next := q.Calibration.NextChange(time.Now())
+		if next.IsZero() || !pause(ctx, next.Sub(time.Now())) {
+			return
+		}
+
+		q.colorMu.Lock()
+		if q.hasColor {
+			q.send()
+		}
+		q.colorMu.Unlock()
+	}
+}
+
+func newEffectQueue(driverBinary string, calibration *Calibration) (*effectQueue, error) {
+	// The driver takes one complete color per line,
+	// so a restarted driver only needs the last one again.
+	supervisor := util.NewSupervisor(driverBinary, "cat")
+	supervisor.RepeatLastWrite = true
+
+	if err := supervisor.Start(); err != nil {
 		return nil, err
 	}
 
 	blocked := make(chan bool, 1)
 	blocked <- false
 
-	return &effectQueue{
-		Blocked:   blocked,
-		StdInPipe: stdinpipe,
-	}, cmd.Start()
+	queue := &effectQueue{
+		Blocked:     blocked,
+		StdInPipe:   supervisor,
+		Calibration: calibration,
+		Driver:      supervisor,
+		waiting:     make(map[int]int),
+	}
+
+	queue.changed = sync.NewCond(queue)
+	return queue, nil
 }
 
 type rgbColor struct {
@@ -67,7 +250,35 @@ type rgbColor struct {
 }
 
 type effect interface {
-	ComposeEffect() chan rgbColor
+	// ComposeEffect sends the colors of the effect on the returned channel,
+	// which is closed when the effect is over or `ctx` was cancelled.
+	ComposeEffect(ctx context.Context) chan rgbColor
+
+	// Endless is true for effects that never stop by themselves.
+	Endless() bool
+}
+
+// emit sends `color` on `c`, unless `ctx` gets cancelled first.
+func emit(ctx context.Context, c chan<- rgbColor, color rgbColor) bool {
+	select {
+	case c <- color:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// pause sleeps for `delay`, unless `ctx` gets cancelled first.
+func pause(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 // Common properties
@@ -96,6 +307,8 @@ type blendEffect struct {
 	StartColor rgbColor
 	EndColor   rgbColor
 	Duration   time.Duration
+	// Hcl blends in the HCL color space, which looks smoother than RGB.
+	Hcl bool
 }
 
 // Warm, orange fire effect for nostalgic reasons.
@@ -107,76 +320,97 @@ type fireEffect struct {
 // COMPOSE METHODS //
 /////////////////////
 
-func (color *rgbColor) ComposeEffect() chan rgbColor {
+// Endless is true if the effect repeats forever.
+func (props *properties) Endless() bool {
+	return props.Repeat < 0
+}
+
+// Endless is false; a color is set just once.
+func (color *rgbColor) Endless() bool {
+	return false
+}
+
+// Endless is false; a blend has a fixed duration.
+func (effect *blendEffect) Endless() bool {
+	return false
+}
+
+// Endless is false; the fire burns out after `Repeat` colors.
+func (effect *fireEffect) Endless() bool {
+	return false
+}
+
+func (color *rgbColor) ComposeEffect(ctx context.Context) chan rgbColor {
 	c := make(chan rgbColor, 1)
 	c <- rgbColor{color.R, color.G, color.B}
 	close(c)
 	return c
 }
 
-func (effect *flashEffect) ComposeEffect() chan rgbColor {
+func (effect *flashEffect) ComposeEffect(ctx context.Context) chan rgbColor {
 	c := make(chan rgbColor, 1)
-	keepLooping := false
-	if effect.Repeat < 0 {
-		keepLooping = true
-	}
 
 	go func() {
-		for {
-			if effect.Repeat <= 0 && !keepLooping {
-				break
+		defer close(c)
+
+		for n := effect.Repeat; n > 0 || effect.Endless(); n-- {
+			if !emit(ctx, c, effect.Color) || !pause(ctx, effect.Delay) {
+				return
 			}
 
-			c <- effect.Color
-			time.Sleep(effect.Delay)
-			c <- rgbColor{0, 0, 0}
-			time.Sleep(effect.Delay)
-			effect.Repeat--
+			if !emit(ctx, c, rgbColor{0, 0, 0}) || !pause(ctx, effect.Delay) {
+				return
+			}
 		}
-		close(c)
 	}()
+
 	return c
 }
 
-func (effect *fadeEffect) ComposeEffect() chan rgbColor {
+func (effect *fadeEffect) ComposeEffect(ctx context.Context) chan rgbColor {
 	c := make(chan rgbColor, 1)
 
-	keepLooping := false
-	if effect.Repeat < 0 {
-		keepLooping = true
-	}
-
 	max := max(effect.Color.R, effect.Color.B, effect.Color.G)
 	go func() {
-		for {
+		defer close(c)
 
-			if effect.Repeat <= 0 && !keepLooping {
-				break
-			}
+		r := int(math.Floor(float64(effect.Color.R) / float64(max) * 100.0))
+		g := int(math.Floor(float64(effect.Color.G) / float64(max) * 100.0))
+		b := int(math.Floor(float64(effect.Color.B) / float64(max) * 100.0))
 
-			r := int(math.Floor(float64(effect.Color.R) / float64(max) * 100.0))
-			g := int(math.Floor(float64(effect.Color.G) / float64(max) * 100.0))
-			b := int(math.Floor(float64(effect.Color.B) / float64(max) * 100.0))
+		step := func(i int) bool {
+			color := rgbColor{uint8((i * r) / 100), uint8((i * g) / 100), uint8((i * b) / 100)}
+			return emit(ctx, c, color) && pause(ctx, effect.Delay)
+		}
+
+		for n := effect.Repeat; n > 0 || effect.Endless(); n-- {
+			// Nothing to fade; do not spin forever:
+			if max == 0 && !pause(ctx, effect.Delay) {
+				return
+			}
 
 			for i := 0; i < int(max); i++ {
-				c <- rgbColor{uint8((i * r) / 100), uint8((i * g) / 100), uint8((i * b) / 100)}
-				time.Sleep(effect.Delay)
+				if !step(i) {
+					return
+				}
 			}
 
-			for i := int(max - 1); i >= 0; i-- {
-				c <- rgbColor{uint8((i * r) / 100), uint8((i * g) / 100), uint8((i * b) / 100)}
-				time.Sleep(effect.Delay)
+			for i := int(max) - 1; i >= 0; i-- {
+				if !step(i) {
+					return
+				}
 			}
-			effect.Repeat--
 		}
-		close(c)
 	}()
+
 	return c
 }
 
-func (effect *blendEffect) ComposeEffect() chan rgbColor {
+func (effect *blendEffect) ComposeEffect(ctx context.Context) chan rgbColor {
 	c := make(chan rgbColor, 1)
 	go func() {
+		defer close(c)
+
 		// How much colors should be generated during the effect?
 		N := 20 * effect.Duration.Seconds()
 
@@ -184,22 +418,32 @@ func (effect *blendEffect) ComposeEffect() chan rgbColor {
 		sg := float64(effect.StartColor.G)
 		sb := float64(effect.StartColor.B)
 
+		start, end := fromRGB(effect.StartColor), fromRGB(effect.EndColor)
+
 		for i := 0; i < int(N); i++ {
 			sr += (float64(effect.EndColor.R) - float64(effect.StartColor.R)) / N
 			sg += (float64(effect.EndColor.G) - float64(effect.StartColor.G)) / N
 			sb += (float64(effect.EndColor.B) - float64(effect.StartColor.B)) / N
 
-			c <- rgbColor{uint8(sr), uint8(sg), uint8(sb)}
-			time.Sleep(time.Duration(1/N*1000) * time.Millisecond)
-		}
+			color := rgbColor{uint8(sr), uint8(sg), uint8(sb)}
+			if effect.Hcl {
+				color = toRGB(start.BlendHcl(end, float64(i+1)/N))
+			}
+
+			if !emit(ctx, c, color) {
+				return
+			}
 
-		close(c)
+			if !pause(ctx, time.Duration(float64(effect.Duration)/N)) {
+				return
+			}
+		}
 	}()
 
 	return c
 }
 
-func (effect *fireEffect) ComposeEffect() chan rgbColor {
+func (effect *fireEffect) ComposeEffect(ctx context.Context) chan rgbColor {
 	fn := func(t, n, jitter int, fac float64) uint8 {
 		j := float64(rand.Int()%(jitter<<1) - jitter)
 		f := float64(t - n>>1)
@@ -211,12 +455,15 @@ func (effect *fireEffect) ComposeEffect() chan rgbColor {
 		defer close(c)
 
 		for t := 0; t < effect.Repeat; t++ {
-			c <- rgbColor{
+			color := rgbColor{
 				fn(t, effect.Repeat, 50, 1.00),
 				fn(t, effect.Repeat, 70, 0.10),
 				fn(t, effect.Repeat, 80, 0.01),
 			}
-			time.Sleep(effect.Delay)
+
+			if !emit(ctx, c, color) || !pause(ctx, effect.Delay) {
+				return
+			}
 		}
 	}()
 
@@ -231,6 +478,21 @@ var (
 )
 
 func parseColor(s string) (*rgbColor, error) {
+	s = strings.TrimSpace(s)
+
+	switch {
+	case strings.HasPrefix(s, "#"):
+		return parseHexColor(s)
+	case strings.HasPrefix(s, "hsv"):
+		return parseHsvColor(s)
+	case strings.HasPrefix(s, "hcl"):
+		return parseHclColor(s)
+	}
+
+	if hex, ok := cssColors[strings.ToLower(s)]; ok {
+		return parseHexColor(hex)
+	}
+
 	matches := regexColorr.FindStringSubmatch(s)
 	if matches == nil {
 		return nil, fmt.Errorf("Bad color: %s", s)
@@ -254,28 +516,47 @@ func parseColor(s string) (*rgbColor, error) {
 }
 
 func parseBlendEffect(s string) (*blendEffect, error) {
-	// Same regex as for properties (by chance)
-	matches := regexProperties.FindStringSubmatch(s)
-	if matches == nil {
+	body, err := unwrapBody(s)
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := splitTopLevel(body, '|')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parts) < 3 || len(parts) > 4 {
 		return nil, fmt.Errorf("Bad blend effect: %s", s)
 	}
 
-	srcColor, err := parseColor(matches[1])
+	srcColor, err := parseColor(parts[0])
 	if err != nil {
-		return nil, fmt.Errorf("Bad source color: `%s`: %v", matches[1], err)
+		return nil, fmt.Errorf("Bad source color: `%s`: %v", parts[0], err)
 	}
 
-	dstColor, err := parseColor(matches[2])
+	dstColor, err := parseColor(parts[1])
 	if err != nil {
-		return nil, fmt.Errorf("Bad destination color: `%s`: %v", matches[2], err)
+		return nil, fmt.Errorf("Bad destination color: `%s`: %v", parts[1], err)
 	}
 
-	duration, err := time.ParseDuration(matches[3])
+	duration, err := time.ParseDuration(parts[2])
 	if err != nil {
-		return nil, fmt.Errorf("Bad duration: `%s`: %v", matches[3], err)
+		return nil, fmt.Errorf("Bad duration: `%s`: %v", parts[2], err)
 	}
 
-	return &blendEffect{*srcColor, *dstColor, duration}, nil
+	useHcl := false
+	if len(parts) == 4 {
+		switch parts[3] {
+		case "hcl":
+			useHcl = true
+		case "rgb":
+		default:
+			return nil, fmt.Errorf("Bad color space: `%s` (rgb or hcl expected)", parts[3])
+		}
+	}
+
+	return &blendEffect{*srcColor, *dstColor, duration, useHcl}, nil
 }
 
 func parseProperties(s string) (*properties, error) {
@@ -329,35 +610,97 @@ func parseFireEffect(s string) (*fireEffect, error) {
 	return &fireEffect{*props}, nil
 }
 
-func parseEffect(s string) (effect, error) {
+// presetLookup returns the effect spec of the preset `name`;
+// it resolves "@<name>" anywhere in an effect.
+type presetLookup func(name string) (string, error)
+
+// parseEffect parses the effect spec `s`. Presets are resolved with `lookup`,
+// which may be nil if no presets are known.
+func parseEffect(s string, lookup presetLookup) (effect, error) {
+	s = strings.TrimSpace(s)
+	if isColor(s) {
+		// Do not return a nil *rgbColor as non-nil effect:
+		color, err := parseColor(s)
+		if err != nil {
+			return nil, err
+		}
+
+		return color, nil
+	}
+
+	if strings.HasPrefix(s, PresetPrefix) {
+		name := strings.TrimPrefix(s, PresetPrefix)
+		if lookup == nil {
+			return nil, fmt.Errorf("No such preset: `%s`", name)
+		}
+
+		spec, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return parseEffect(spec, lookup)
+	}
+
 	sepIdx := strings.Index(s, "{")
+	if sepIdx < 0 {
+		return nil, fmt.Errorf("Bad effect: `%s`", s)
+	}
+
 	name, rest := s[:sepIdx], s[sepIdx:]
 
+	// The parsers return typed pointers; an error must not come
+	// with a non-nil effect holding a nil pointer:
+	var parsed effect
+	var err error
+
 	switch name {
 	case "", "c", "color":
-		return parseColor(rest)
+		parsed, err = parseColor(rest)
+	case "hsv", "hcl":
+		parsed, err = parseColor(s)
 	case "fade":
-		return parseFadeEffect(rest)
+		parsed, err = parseFadeEffect(rest)
 	case "flash":
-		return parseFlashEffect(rest)
+		parsed, err = parseFlashEffect(rest)
 	case "fire":
-		return parseFireEffect(rest)
+		parsed, err = parseFireEffect(rest)
 	case "blend":
-		return parseBlendEffect(rest)
+		parsed, err = parseBlendEffect(rest)
+	case "seq":
+		parsed, err = parseSeqEffect(rest, lookup)
+	case "loop":
+		parsed, err = parseLoopEffect(rest, lookup)
+	case "mix":
+		parsed, err = parseMixEffect(rest, lookup)
+	case "wait":
+		parsed, err = parseWaitEffect(rest)
 	default:
 		return nil, fmt.Errorf("Bad effect name: `%s`", name)
 	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
 }
 
 //////////// SERVER MAIN //////////////
 
-func lock(queue *effectQueue) {
+// lock takes the Blocked token; it gives up after a timeout or when `ctx`
+// is cancelled. It returns true if the token was taken.
+func lock(ctx context.Context, queue *effectQueue) bool {
 	timer := time.NewTimer(5 * time.Second)
+	defer timer.Stop()
+
 	select {
 	case <-queue.Blocked:
-		break
+		return true
 	case <-timer.C:
-		break
+		return false
+	case <-ctx.Done():
+		return false
 	}
 }
 
@@ -371,9 +714,119 @@ func unlock(queue *effectQueue) {
 	}
 }
 
-func handleRequest(conn io.ReadWriteCloser, queue *effectQueue) {
+// holdLock stops the running effect and keeps all other effects from playing
+// until the returned context is cancelled (by ReleaseLock or when `ctx` is done).
+// Effects with a priority above `prio` stop the lock.
+func holdLock(ctx context.Context, queue *effectQueue, prio int) (context.Context, context.CancelFunc, error) {
+	lockCtx, pb, err := queue.acquire(ctx, prio)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	locked := lock(lockCtx, queue)
+
+	go func() {
+		<-lockCtx.Done()
+		if locked {
+			unlock(queue)
+		}
+
+		queue.release(pb)
+	}()
+
+	return lockCtx, pb.cancel, nil
+}
+
+// HoldLock handles !lock. The lock outlives the connection that took it; it ends
+// with ReleaseLock (sent by any connection) or when an effect with a priority
+// above `prio` plays. Locking again while locked does nothing.
+func (q *effectQueue) HoldLock(ctx context.Context, prio int) error {
+	q.lockMu.Lock()
+	defer q.lockMu.Unlock()
+
+	if q.lockCtx != nil && q.lockCtx.Err() == nil {
+		return nil
+	}
+
+	lockCtx, unlockFn, err := holdLock(ctx, q, prio)
+	if err != nil {
+		return err
+	}
+
+	q.lockCtx, q.unlockFn = lockCtx, unlockFn
+	return nil
+}
+
+// ReleaseLock handles !unlock; it ends the lock taken by HoldLock, if any.
+func (q *effectQueue) ReleaseLock() {
+	q.lockMu.Lock()
+	defer q.lockMu.Unlock()
+
+	if q.unlockFn != nil {
+		q.unlockFn()
+		q.lockCtx, q.unlockFn = nil, nil
+	}
+}
+
+// queuedEffect is an effect waiting to be played by a connection.
+type queuedEffect struct {
+	effect effect
+	prio   int
+}
+
+// playEffects plays the effects of a single connection in order.
+// Once the connection is closed (`connCtx` is cancelled), endless effects
+// stop; all others are still played.
+func playEffects(ctx, connCtx context.Context, queue *effectQueue, effects <-chan queuedEffect) {
+	for qe := range effects {
+		effectCtx := ctx
+		if qe.effect.Endless() {
+			effectCtx = connCtx
+		}
+
+		if err := queue.Push(effectCtx, qe.effect, qe.prio); err != nil && err != context.Canceled {
+			log.Printf("Failed to play effect: %v", err)
+		}
+	}
+}
+
+// lightdPermissions is the permission needed by commands that only read;
+// all other commands and effects need PermControl.
+var lightdPermissions = map[string]util.Permission{
+	"!health": util.PermRead,
+	"!list":   util.PermRead,
+}
+
+func commandPermission(line string) util.Permission {
+	if perm, ok := lightdPermissions[strings.Fields(line)[0]]; ok {
+		return perm
+	}
+
+	return util.PermControl
+}
+
+// replyErr logs `err` and sends it as "ERR <message>", so clients waiting
+// for an answer do not hang.
+func replyErr(conn io.Writer, err error) {
+	log.Printf("%v", err)
+	if _, err := fmt.Fprintf(conn, "ERR %v\n", err); err != nil {
+		log.Printf("Failed to answer error response: %v", err)
+	}
+}
+
+func handleRequest(ctx context.Context, conn io.ReadWriteCloser, queue *effectQueue, presets *presets, access *util.Access) {
 	defer util.Closer(conn)
 
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	effects := make(chan queuedEffect, 16)
+	defer close(effects)
+
+	go playEffects(ctx, connCtx, queue, effects)
+
+	prio := PriorityNormal
+
 	scanner := bufio.NewScanner(conn)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -383,36 +836,103 @@ func handleRequest(conn io.ReadWriteCloser, queue *effectQueue) {
 			continue
 		}
 
-		switch line {
-		case "!lock":
-			lock(queue)
+		if strings.HasPrefix(line, "!auth ") {
+			if err := access.Auth(strings.TrimPrefix(line, "!auth ")); err != nil {
+				replyErr(conn, fmt.Errorf("Authentication failed: %v", err))
+			}
+
+			continue
+		}
+
+		if line == "!close" {
+			break
+		}
+
+		if err := access.Check(line, commandPermission(line)); err != nil {
+			replyErr(conn, err)
+			continue
+		}
+
+		switch {
+		case line == "!lock":
+			lockPrio := PriorityLock
+			if prio > lockPrio {
+				lockPrio = prio
+			}
+
+			if err := queue.HoldLock(ctx, lockPrio); err != nil {
+				replyErr(conn, fmt.Errorf("Failed to lock: %v", err))
+				continue
+			}
 
 			if _, err := conn.Write([]byte("OK\n")); err != nil {
 				log.Printf("Failed to answer lock response: %v", err)
 			}
 
 			continue
-		case "!unlock":
-			unlock(queue)
+		case line == "!unlock":
+			queue.ReleaseLock()
 
 			if _, err := conn.Write([]byte("OK\n")); err != nil {
 				log.Printf("Failed to answer unlock response: %v", err)
 			}
 
 			continue
-		case "!close":
-			break
+		case line == "!stop":
+			queue.Stop()
+			continue
+		case strings.HasPrefix(line, "!define "):
+			name, spec, err := parseDefinition(strings.TrimPrefix(line, "!define "))
+			if err == nil {
+				err = presets.Define(name, spec)
+			}
+
+			if err != nil {
+				log.Printf("Unable to define preset: %v", err)
+			}
+
+			continue
+		case line == "!health":
+			health := queue.Driver.Health().String() + "\nOK\n"
+			if _, err := conn.Write([]byte(health)); err != nil {
+				log.Printf("Failed to answer health response: %v", err)
+			}
+
+			continue
+		case line == "!list":
+			if err := presets.List(conn); err != nil {
+				log.Printf("Failed to list presets: %v", err)
+				continue
+			}
+
+			if _, err := conn.Write([]byte("OK\n")); err != nil {
+				log.Printf("Failed to answer list response: %v", err)
+			}
+
+			continue
+		case strings.HasPrefix(line, "!priority "):
+			newPrio, err := strconv.Atoi(strings.TrimPrefix(line, "!priority "))
+			if err != nil {
+				log.Printf("Bad priority: %v", err)
+				continue
+			}
+
+			prio = newPrio
+			continue
 		}
 
-		effect, err := parseEffect(line)
+		effect, err := parseEffect(line, presets.Spec)
 		if err != nil {
 			log.Printf("Unable to process effect: %v", err)
 			continue
 		}
 
-		lock(queue)
-		queue.Push(effect)
-		unlock(queue)
+		// Do not stop reading (and answering !unlock) when full:
+		select {
+		case effects <- queuedEffect{effect, prio}:
+		default:
+			log.Printf("Too many queued effects; dropping `%s`", line)
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -432,6 +952,19 @@ type Config struct {
 	Port int
 	// DriverBinary is the name of the binary lightd will output rgb triples on.
 	DriverBinary string
+	// Token is sent by clients to authenticate with "!auth <token>".
+	Token string
+	// ControlToken and ReadToken protect lightd; see util.Tokens.
+	// Only !health and !list are reading commands.
+	ControlToken string
+	ReadToken    string
+	// Priority of the effects sent by clients; see the !priority command.
+	Priority int
+	// PresetsFile has one "<name> <effect>" preset per line.
+	// It is fine if it does not exist.
+	PresetsFile string
+	// Calibration is applied to every color sent to the driver (may be nil).
+	Calibration *Calibration
 }
 
 func cancelled(ctx context.Context) bool {
@@ -446,41 +979,47 @@ func cancelled(ctx context.Context) bool {
 // Run starts lightd with the options specified in `cfg`,
 // cancelling services when `ctx` is cancelled.
 func Run(cfg *Config, ctx context.Context) error {
-	queue, err := newEffectQueue(cfg.DriverBinary)
+	queue, err := newEffectQueue(cfg.DriverBinary, cfg.Calibration)
 	if err != nil {
 		log.Printf("Unable to hook up to lightd: %v", err)
 		return err
 	}
 
-	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
-	lsn, err := net.Listen("tcp", addr)
+	defer util.Closer(queue.Driver)
+
+	go queue.recalibrate(ctx)
+
+	presets := newPresets()
+	if cfg.PresetsFile != "" {
+		if err := presets.Load(cfg.PresetsFile); err != nil && !os.IsNotExist(err) {
+			log.Printf("Unable to load presets: %v", err)
+			return err
+		}
+	}
+
+	lsn, err := util.Listen(cfg.Host, cfg.Port)
 	if err != nil {
 		log.Printf("Error listening: %v", err.Error())
 		return err
 	}
 
 	defer util.Closer(lsn)
-	log.Println("Listening on " + addr)
+	log.Printf("Listening on %s", lsn.Addr())
 
-	for !cancelled(ctx) {
-		if tcpLsn, ok := lsn.(*net.TCPListener); ok {
-			if err := tcpLsn.SetDeadline(time.Now().Add(2 * time.Second)); err != nil {
-				log.Printf("Setting deadline failed: %v", err)
-				return err
-			}
-		}
-
-		conn, err := lsn.Accept()
-		if err, ok := err.(*net.OpError); ok && err.Timeout() {
-			continue
-		}
+	tokens := util.Tokens{Control: cfg.ControlToken, Read: cfg.ReadToken}
 
+	for !cancelled(ctx) {
+		conn, err := util.Accept(lsn, 2*time.Second)
 		if err != nil {
 			log.Printf("Error accepting: %v", err.Error())
 			return err
 		}
 
-		go handleRequest(conn, queue)
+		if conn == nil {
+			continue
+		}
+
+		go handleRequest(ctx, conn, queue, presets, util.NewAccess(tokens))
 	}
 
 	return nil
@@ -5,6 +5,8 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
 	"github.com/studentkittens/eulenfunk/ambilight"
 	"github.com/studentkittens/eulenfunk/automount"
@@ -12,6 +14,7 @@ import (
 	"github.com/studentkittens/eulenfunk/lightd"
 	"github.com/studentkittens/eulenfunk/ui"
 	"github.com/studentkittens/eulenfunk/ui/mpd"
+	"github.com/studentkittens/eulenfunk/util"
 	"github.com/urfave/cli"
 	"golang.org/x/net/context"
 )
@@ -27,10 +30,11 @@ const (
 
 func handleInfo(ctx *cli.Context, dropout context.Context) error {
 	client, err := mpd.NewClient(&mpd.Config{
-		MPDHost:     ctx.String("mpd-host"),
-		MPDPort:     ctx.Int("mpd-port"),
-		DisplayHost: ctx.String("display-host"),
-		DisplayPort: ctx.Int("display-port"),
+		MPDHost:      ctx.String("mpd-host"),
+		MPDPort:      ctx.Int("mpd-port"),
+		DisplayHost:  ctx.String("display-host"),
+		DisplayPort:  ctx.Int("display-port"),
+		DisplayToken: ctx.String("display-token"),
 	}, dropout)
 
 	if err != nil {
@@ -44,47 +48,103 @@ func handleInfo(ctx *cli.Context, dropout context.Context) error {
 
 func handleUI(ctx *cli.Context, dropout context.Context) error {
 	return ui.Run(&ui.Config{
-		Width:         ctx.GlobalInt("width"),
-		Height:        ctx.GlobalInt("height"),
-		DisplayHost:   ctx.String("display-host"),
-		DisplayPort:   ctx.Int("display-port"),
-		MPDHost:       ctx.String("mpd-host"),
-		MPDPort:       ctx.Int("mpd-port"),
-		AmbilightHost: ctx.String("ambi-host"),
-		AmbilightPort: ctx.Int("ambi-port"),
-		LightdHost:    ctx.String("lightd-host"),
-		LightdPort:    ctx.Int("lightd-port"),
+		Width:            ctx.GlobalInt("width"),
+		Height:           ctx.GlobalInt("height"),
+		DisplayHost:      ctx.String("display-host"),
+		DisplayPort:      ctx.Int("display-port"),
+		DisplayToken:     ctx.String("display-token"),
+		MPDHost:          ctx.String("mpd-host"),
+		MPDPort:          ctx.Int("mpd-port"),
+		AmbilightHost:    ctx.String("ambi-host"),
+		AmbilightPort:    ctx.Int("ambi-port"),
+		AmbilightToken:   ctx.String("ambi-token"),
+		LightdHost:       ctx.String("lightd-host"),
+		LightdPort:       ctx.Int("lightd-port"),
+		LightdToken:      ctx.String("lightd-token"),
+		WeatherLatitude:  ctx.Float64("weather-latitude"),
+		WeatherLongitude: ctx.Float64("weather-longitude"),
+		HootSound:        ctx.String("hoot-sound"),
+		SysinfoScript:    ctx.String("sysinfo-script"),
 	}, dropout)
 }
 
+func parseCalibration(ctx *cli.Context) (*lightd.Calibration, error) {
+	return lightd.ParseCalibration(
+		ctx.String("led-gamma"),
+		ctx.String("led-white-balance"),
+		ctx.Float64("led-max-brightness"),
+		ctx.String("led-night"),
+		ctx.Float64("led-night-brightness"),
+	)
+}
+
 func handleLightd(ctx *cli.Context, dropout context.Context) error {
+	calibration, err := parseCalibration(ctx)
+	if err != nil {
+		return err
+	}
+
 	cfg := &lightd.Config{
 		Host:         ctx.String("lightd-host"),
 		Port:         ctx.Int("lightd-port"),
+		Token:        ctx.String("lightd-token"),
+		ControlToken: ctx.String("control-token"),
+		ReadToken:    ctx.String("read-token"),
 		DriverBinary: ctx.String("driver"),
+		Priority:     ctx.Int("priority"),
+		PresetsFile:  ctx.String("presets"),
+		Calibration:  calibration,
 	}
 
 	if effect := ctx.String("send"); effect != "" {
 		return lightd.Send(cfg, effect)
 	}
 
+	if ctx.Bool("stop") {
+		return lightd.Stop(cfg)
+	}
+
+	if definition := ctx.String("define"); definition != "" {
+		return lightd.Send(cfg, "!define "+definition)
+	}
+
+	if ctx.Bool("list-presets") {
+		presets, err := lightd.Presets(cfg)
+		if err != nil {
+			return err
+		}
+
+		for _, preset := range presets {
+			fmt.Println(preset)
+		}
+
+		return nil
+	}
+
+	if ctx.Bool("health") {
+		health, err := lightd.Health(cfg)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(health)
+		return nil
+	}
+
 	if ctx.Bool("lock") || ctx.Bool("unlock") {
 		locker, err := lightd.NewLocker(cfg)
 		if err != nil {
 			return err
 		}
 
+		// The lock stays after closing, until --unlock:
+		defer util.Closer(locker)
+
 		if ctx.Bool("lock") {
-			if err := locker.Lock(); err != nil {
-				log.Printf("lightd-lock failed: %v", err)
-			}
-		} else {
-			if err := locker.Unlock(); err != nil {
-				log.Printf("lightd-unlock failed: %v", err)
-			}
+			return locker.Lock()
 		}
 
-		return locker.Close()
+		return locker.Unlock()
 	}
 
 	return lightd.Run(cfg, dropout)
@@ -94,8 +154,18 @@ func handleDisplayClient(ctx *cli.Context, dropout context.Context) error {
 	cfg := &display.Config{
 		Host:   ctx.String("display-host"),
 		Port:   ctx.Int("display-port"),
+		Token:  ctx.String("display-token"),
 		Width:  ctx.GlobalInt("width"),
 		Height: ctx.GlobalInt("height"),
+		Output: ctx.String("output"),
+	}
+
+	if ctx.Bool("outputs") {
+		return display.OutputsClient(cfg, dropout)
+	}
+
+	if ctx.Bool("list") {
+		return display.ListClient(cfg, dropout)
 	}
 
 	if ctx.Bool("dump") {
@@ -114,19 +184,58 @@ func handleDisplayClient(ctx *cli.Context, dropout context.Context) error {
 }
 
 func handleDisplayServer(ctx *cli.Context, dropout context.Context) error {
+	outputs := []display.OutputConfig{}
+	for _, spec := range ctx.StringSlice("output") {
+		oc, err := display.ParseOutput(spec)
+		if err != nil {
+			return err
+		}
+
+		outputs = append(outputs, oc)
+	}
+
 	return display.Run(&display.Config{
-		Host:         ctx.Parent().String("display-host"),
-		Port:         ctx.Parent().Int("display-port"),
-		Width:        ctx.GlobalInt("width"),
-		Height:       ctx.GlobalInt("height"),
-		NoEncoding:   ctx.Bool("no-encoding"),
-		DriverBinary: ctx.String("driver"),
+		Host:           ctx.Parent().String("display-host"),
+		Port:           ctx.Parent().Int("display-port"),
+		Width:          ctx.GlobalInt("width"),
+		Height:         ctx.GlobalInt("height"),
+		Encoding:       ctx.String("encoding"),
+		NoEncoding:     ctx.Bool("no-encoding"),
+		DriverBinary:   ctx.String("driver"),
+		CollectWindows: ctx.Bool("collect-windows"),
+		HTTPAddr:       ctx.String("http"),
+		Backlight:      ctx.Int("backlight"),
+		Contrast:       ctx.Int("contrast"),
+		DimAfter:       ctx.Duration("dim-after"),
+		DimLevel:       ctx.Int("dim-level"),
+		Night:          ctx.String("night"),
+		NightLevel:     ctx.Int("night-level"),
+		IdleAfter:      ctx.Duration("idle-after"),
+		Screensaver:    ctx.String("screensaver"),
+		Outputs:        outputs,
+		RecordFile:     ctx.String("record"),
+		ControlToken:   ctx.String("control-token"),
+		ReadToken:      ctx.String("read-token"),
 	}, dropout)
 }
 
+func handleDisplayReplay(ctx *cli.Context, dropout context.Context) error {
+	path := ctx.Args().First()
+	if path == "" {
+		return fmt.Errorf("Usage: display replay [--speed <factor>] <session file>")
+	}
+
+	return display.ReplayClient(&display.Config{
+		Host:  ctx.Parent().String("display-host"),
+		Port:  ctx.Parent().Int("display-port"),
+		Token: ctx.Parent().String("display-token"),
+	}, dropout, path, ctx.Float64("speed"))
+}
+
 func handleAmbilightCommand(ctx *cli.Context, cfg *ambilight.Config) (bool, error) {
 	on, off, quit, state := ctx.Bool("on"), ctx.Bool("off"), ctx.Bool("quit"), ctx.Bool("state")
-	if !on && !off && !quit && !state {
+	health := ctx.Bool("health")
+	if !on && !off && !quit && !state && !health {
 		return false, nil
 	}
 
@@ -148,6 +257,15 @@ func handleAmbilightCommand(ctx *cli.Context, cfg *ambilight.Config) (bool, erro
 
 		fmt.Printf("%t\n", enabled)
 		return true, nil
+	case health:
+		driverHealth, err := client.Health()
+		if err != nil {
+			log.Printf("Failed to get health: %v", err)
+			return true, err
+		}
+
+		fmt.Println(driverHealth)
+		return true, nil
 	case quit:
 		return true, client.Quit()
 	}
@@ -159,6 +277,11 @@ func handleAmbilight(ctx *cli.Context, dropout context.Context) error {
 	musicDir := ctx.String("music-dir")
 	moodyDir := ctx.String("mood-dir")
 
+	calibration, err := parseCalibration(ctx)
+	if err != nil {
+		return err
+	}
+
 	cfg := &ambilight.Config{
 		AmbiHost:           ctx.String("ambi-host"),
 		AmbiPort:           ctx.Int("ambi-port"),
@@ -166,10 +289,15 @@ func handleAmbilight(ctx *cli.Context, dropout context.Context) error {
 		MPDPort:            ctx.Int("mpd-port"),
 		LightdHost:         ctx.String("lightd-host"),
 		LightdPort:         ctx.Int("lightd-port"),
+		LightdToken:        ctx.String("lightd-token"),
+		AmbiToken:          ctx.String("ambi-token"),
+		AmbiControlToken:   ctx.String("control-token"),
+		AmbiReadToken:      ctx.String("read-token"),
 		UpdateMoodDatabase: ctx.Bool("update-mood-db"),
 		BinaryName:         ctx.String("driver"),
 		MusicDir:           musicDir,
 		MoodDir:            moodyDir,
+		Calibration:        calibration,
 	}
 
 	handled, err := handleAmbilightCommand(ctx, cfg)
@@ -196,6 +324,12 @@ func handleAutomount(ctx *cli.Context, dropout context.Context) error {
 		MPDHost:       ctx.String("mpd-host"),
 		MPDPort:       ctx.Int("mpd-port"),
 		MusicDir:      ctx.String("music-dir"),
+		DisplayHost:   ctx.String("display-host"),
+		DisplayPort:   ctx.Int("display-port"),
+		DisplayToken:  ctx.String("display-token"),
+
+		AutomountToken:        ctx.String("automount-token"),
+		AutomountControlToken: ctx.String("control-token"),
 	}
 
 	if ctx.Bool("quit") {
@@ -272,7 +406,7 @@ func main() {
 			Name:   "width",
 			Value:  DefaultWidth,
 			Usage:  "Width of the LCD screen",
-			EnvVar: "LCD_HEIGHT",
+			EnvVar: "LCD_WIDTH",
 		},
 		cli.IntFlag{
 			Name:   "height",
@@ -280,6 +414,17 @@ func main() {
 			Usage:  "Height of the LCD screen",
 			EnvVar: "LCD_HEIGHT",
 		},
+		cli.StringFlag{
+			Name:   "config",
+			Value:  DefaultConfigPath,
+			Usage:  "Config file with defaults for all flags",
+			EnvVar: "EULENFUNK_CONFIG",
+		},
+	}
+
+	// Flags of the commands are known only after this point:
+	app.Before = func(ctx *cli.Context) error {
+		return applyConfigFile(app, ctx)
 	}
 
 	//////////////////////
@@ -305,7 +450,7 @@ func main() {
 		cli.StringFlag{
 			Name:   "display-host",
 			Value:  "localhost",
-			Usage:  "Display server hostname",
+			Usage:  "Display server hostname (or unix:///<path> for a unix socket)",
 			EnvVar: "DISPLAY_HOST",
 		},
 		cli.IntFlag{
@@ -314,13 +459,19 @@ func main() {
 			Usage:  "Display server port",
 			EnvVar: "DISPLAY_PORT",
 		},
+		cli.StringFlag{
+			Name:   "display-token",
+			Value:  "",
+			Usage:  "Token to authenticate with the display server",
+			EnvVar: "DISPLAY_TOKEN",
+		},
 	}
 
 	ambiNetFlags := []cli.Flag{
 		cli.StringFlag{
 			Name:   "ambi-host",
 			Value:  "localhost",
-			Usage:  "Host of the internal control server",
+			Usage:  "Host of the internal control server (or unix:///<path>)",
 			EnvVar: "AMBI_HOST",
 		},
 		cli.IntFlag{
@@ -329,13 +480,53 @@ func main() {
 			Usage:  "Port of the internal control server",
 			EnvVar: "AMBI_PORT",
 		},
+		cli.StringFlag{
+			Name:   "ambi-token",
+			Value:  "",
+			Usage:  "Token to authenticate with the internal control server",
+			EnvVar: "AMBI_TOKEN",
+		},
+	}
+
+	// Calibration of the LED strip; lightd and ambilight drive it both.
+	calibrationFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:   "led-gamma",
+			Value:  "1",
+			Usage:  "Gamma of the LED strip, as <all> or <r>,<g>,<b>",
+			EnvVar: "LED_GAMMA",
+		},
+		cli.StringFlag{
+			Name:   "led-white-balance",
+			Value:  "1",
+			Usage:  "Scale the channels of the LED strip (0-1), as <all> or <r>,<g>,<b>",
+			EnvVar: "LED_WHITE_BALANCE",
+		},
+		cli.Float64Flag{
+			Name:   "led-max-brightness",
+			Value:  1,
+			Usage:  "Limit the brightness of the LED strip (0-1)",
+			EnvVar: "LED_MAX_BRIGHTNESS",
+		},
+		cli.StringFlag{
+			Name:   "led-night",
+			Value:  "",
+			Usage:  "Limit the brightness further in this time range (like 22:00-07:00)",
+			EnvVar: "LED_NIGHT",
+		},
+		cli.Float64Flag{
+			Name:   "led-night-brightness",
+			Value:  0.2,
+			Usage:  "Brightness limit during --led-night (0-1)",
+			EnvVar: "LED_NIGHT_BRIGHTNESS",
+		},
 	}
 
 	lightdNetFlags := []cli.Flag{
 		cli.StringFlag{
 			Name:   "lightd-host",
 			Value:  "localhost",
-			Usage:  "Host of the lightd server",
+			Usage:  "Host of the lightd server (or unix:///<path>)",
 			EnvVar: "LIGHTD_HOST",
 		},
 		cli.IntFlag{
@@ -344,6 +535,12 @@ func main() {
 			Usage:  "Port of the lightd server",
 			EnvVar: "LIGHTD_PORT",
 		},
+		cli.StringFlag{
+			Name:   "lightd-token",
+			Value:  "",
+			Usage:  "Token to authenticate with the lightd server",
+			EnvVar: "LIGHTD_TOKEN",
+		},
 	}
 
 	////////////////////////
@@ -359,16 +556,42 @@ func main() {
 		Name:   "ui",
 		Usage:  "Handle window rendering and input control",
 		Action: withCancelCtx(dropout, handleUI),
-		Flags:  concat(displaydNetFlags, mpdNetFlags, ambiNetFlags, lightdNetFlags),
+		Flags: concat(displaydNetFlags, mpdNetFlags, ambiNetFlags, lightdNetFlags, []cli.Flag{
+			// Defaults to Augsburg:
+			cli.Float64Flag{
+				Name:   "weather-latitude",
+				Value:  48.3830555,
+				Usage:  "Latitude of the place to show the weather for",
+				EnvVar: "UI_WEATHER_LATITUDE",
+			},
+			cli.Float64Flag{
+				Name:   "weather-longitude",
+				Value:  10.8830555,
+				Usage:  "Longitude of the place to show the weather for",
+				EnvVar: "UI_WEATHER_LONGITUDE",
+			},
+			cli.StringFlag{
+				Name:   "hoot-sound",
+				Value:  "/root/hoot.wav",
+				Usage:  "Sound played by the schuhu menu entry (empty: none)",
+				EnvVar: "UI_HOOT_SOUND",
+			},
+			cli.StringFlag{
+				Name:   "sysinfo-script",
+				Value:  "radio-sysinfo.sh",
+				Usage:  "Script printing the system information window",
+				EnvVar: "UI_SYSINFO_SCRIPT",
+			},
+		}),
 	}, {
 		Name:   "automount",
 		Usage:  "Control the automount for usb sticks filled with music",
 		Action: withCancelCtx(dropout, handleAutomount),
-		Flags: concat(mpdNetFlags, []cli.Flag{
+		Flags: concat(mpdNetFlags, displaydNetFlags, []cli.Flag{
 			cli.StringFlag{
 				Name:   "automount-host",
 				Value:  "localhost",
-				Usage:  "The host on which the control daemon listens on",
+				Usage:  "The host on which the control daemon listens on (or unix:///<path>)",
 				EnvVar: "AUTOMOUNT_HOST",
 			},
 			cli.IntFlag{
@@ -377,6 +600,18 @@ func main() {
 				Usage:  "The port on which the control daemon listens on",
 				EnvVar: "AUTOMOUNT_PORT",
 			},
+			cli.StringFlag{
+				Name:   "automount-token",
+				Value:  "",
+				Usage:  "Token to authenticate with the control daemon",
+				EnvVar: "AUTOMOUNT_TOKEN",
+			},
+			cli.StringFlag{
+				Name:   "control-token",
+				Value:  "",
+				Usage:  "Clients need this token to control automountd (empty: no protection)",
+				EnvVar: "AUTOMOUNT_CONTROL_TOKEN",
+			},
 			cli.StringFlag{
 				Name:  "device,d",
 				Value: "",
@@ -402,25 +637,65 @@ func main() {
 		Name:   "lightd",
 		Usage:  "Utility server to lock the led ownage and enable nice atomic effects",
 		Action: withCancelCtx(dropout, handleLightd),
-		Flags: concat(lightdNetFlags, []cli.Flag{
+		Flags: concat(lightdNetFlags, calibrationFlags, []cli.Flag{
 			cli.StringFlag{
 				Name:   "driver,d",
 				Value:  "catlight",
 				Usage:  "Which driver binary to use to send colors to",
 				EnvVar: "LIGHTD_DRIVER",
 			},
+			cli.StringFlag{
+				Name:   "control-token",
+				Value:  "",
+				Usage:  "Clients need this token to control lightd (empty: no protection)",
+				EnvVar: "LIGHTD_CONTROL_TOKEN",
+			},
+			cli.StringFlag{
+				Name:   "read-token",
+				Value:  "",
+				Usage:  "Clients need this token for --health and --list-presets (empty: anyone may read)",
+				EnvVar: "LIGHTD_READ_TOKEN",
+			},
+			cli.StringFlag{
+				Name:   "presets",
+				Value:  "/etc/lightd-presets.conf",
+				Usage:  "File with one preset per line, as <name> <effect>",
+				EnvVar: "LIGHTD_PRESETS",
+			},
 			cli.StringFlag{
 				Name:  "send,s",
-				Usage: "Send an effect",
+				Usage: "Send an effect or the name of a preset",
+				Value: "",
+			},
+			cli.StringFlag{
+				Name:  "define",
+				Usage: "Define a preset on the running lightd, given as <name> <effect>",
 				Value: "",
 			},
+			cli.BoolFlag{
+				Name:  "list-presets",
+				Usage: "List the presets known to lightd",
+			},
+			cli.BoolFlag{
+				Name:  "health",
+				Usage: "Print the health of the driver of lightd (up, down or up(<restarts>))",
+			},
+			cli.IntFlag{
+				Name:  "priority",
+				Usage: "Priority of the effect given by --send; it stops running effects of the same or lower priority",
+				Value: lightd.PriorityNormal,
+			},
+			cli.BoolFlag{
+				Name:  "stop",
+				Usage: "Stop the currently running effect",
+			},
 			cli.BoolFlag{
 				Name:  "lock,l",
-				Usage: "Lock the light",
+				Usage: "Lock the light until --unlock; only higher priority effects play meanwhile",
 			},
 			cli.BoolFlag{
 				Name:  "unlock,u",
-				Usage: "Unlock the lights",
+				Usage: "Unlock the light again",
 			},
 		}),
 	}, {
@@ -437,6 +712,19 @@ func main() {
 				Name:  "quit,q",
 				Usage: "Quit the display server",
 			},
+			cli.BoolFlag{
+				Name:  "list,l",
+				Usage: "List all windows with their owner and size",
+			},
+			cli.BoolFlag{
+				Name:  "outputs",
+				Usage: "List all outputs of the display server",
+			},
+			cli.StringFlag{
+				Name:  "output,o",
+				Value: "",
+				Usage: "Which output to show/modify (default: " + display.DefaultOutput + ")",
+			},
 			cli.BoolFlag{
 				Name:  "update,u",
 				Usage: "For --dump; updates output when given",
@@ -455,14 +743,113 @@ func main() {
 				cli.StringFlag{
 					Name:   "driver",
 					Value:  "cat",
-					Usage:  "Driver program that takes the display output",
+					Usage:  "Driver program that takes the display output (`virtual` for a headless one)",
 					EnvVar: "DISPLAY_DRIVER",
 				},
+				cli.StringFlag{
+					Name:   "encoding",
+					Value:  display.DefaultEncoding,
+					Usage:  "Character set of the display (" + strings.Join(display.EncoderNames(), ", ") + ")",
+					EnvVar: "DISPLAY_ENCODING",
+				},
 				cli.BoolFlag{
 					Name:   "no-encoding",
-					Usage:  "Disables special encoding for optimized LCD output for testing",
+					Usage:  "Alias for --encoding utf8; useful for testing on a terminal",
 					EnvVar: "DISPLAY_NO_ENCODING",
 				},
+				cli.BoolFlag{
+					Name:   "collect-windows",
+					Usage:  "Destroy the windows of a client when it disconnects",
+					EnvVar: "DISPLAY_COLLECT_WINDOWS",
+				},
+				cli.StringFlag{
+					Name:   "http",
+					Value:  "",
+					Usage:  "Serve a live mirror of the display to browsers on this address (e.g. :8080)",
+					EnvVar: "DISPLAY_HTTP",
+				},
+				cli.IntFlag{
+					Name:   "backlight",
+					Value:  display.DefaultBacklight,
+					Usage:  "Initial backlight level (1-100)",
+					EnvVar: "DISPLAY_BACKLIGHT",
+				},
+				cli.IntFlag{
+					Name:   "contrast",
+					Value:  display.DefaultContrast,
+					Usage:  "Initial contrast level (1-100)",
+					EnvVar: "DISPLAY_CONTRAST",
+				},
+				cli.DurationFlag{
+					Name:   "dim-after",
+					Value:  10 * time.Minute,
+					Usage:  "Dim the backlight when the knob was not touched for this long (0 to disable)",
+					EnvVar: "DISPLAY_DIM_AFTER",
+				},
+				cli.IntFlag{
+					Name:   "dim-level",
+					Value:  20,
+					Usage:  "Backlight level when dimmed",
+					EnvVar: "DISPLAY_DIM_LEVEL",
+				},
+				cli.StringFlag{
+					Name:   "night",
+					Value:  "",
+					Usage:  "Daily time range with a dark backlight (e.g. 22:00-07:00)",
+					EnvVar: "DISPLAY_NIGHT",
+				},
+				cli.IntFlag{
+					Name:   "night-level",
+					Value:  0,
+					Usage:  "Backlight level at night",
+					EnvVar: "DISPLAY_NIGHT_LEVEL",
+				},
+				cli.DurationFlag{
+					Name:   "idle-after",
+					Value:  30 * time.Minute,
+					Usage:  "Show the screensaver when nothing happened for this long (0 to disable)",
+					EnvVar: "DISPLAY_IDLE_AFTER",
+				},
+				cli.StringFlag{
+					Name:   "screensaver",
+					Value:  display.ScreensaverClock,
+					Usage:  "Screensaver shown when idle (" + strings.Join(display.ScreensaverModes(), ", ") + ")",
+					EnvVar: "DISPLAY_SCREENSAVER",
+				},
+				cli.StringSliceFlag{
+					Name:  "output",
+					Usage: "Drive a further display, given as <name>:<w>x<h>:<driver>[:<encoding>]; may be repeated",
+				},
+				cli.StringFlag{
+					Name:   "record",
+					Value:  "",
+					Usage:  "Record all commands to this session file (see `display replay`)",
+					EnvVar: "DISPLAY_RECORD",
+				},
+				cli.StringFlag{
+					Name:   "control-token",
+					Value:  "",
+					Usage:  "Clients need this token to control displayd (empty: no protection)",
+					EnvVar: "DISPLAY_CONTROL_TOKEN",
+				},
+				cli.StringFlag{
+					Name:   "read-token",
+					Value:  "",
+					Usage:  "Clients need this token to read from displayd (empty: anyone may read)",
+					EnvVar: "DISPLAY_READ_TOKEN",
+				},
+			},
+		}, {
+			Name:      "replay",
+			Usage:     "Play a recorded session back into a running display server",
+			ArgsUsage: "<session file>",
+			Action:    withCancelCtx(dropout, handleDisplayReplay),
+			Flags: []cli.Flag{
+				cli.Float64Flag{
+					Name:  "speed",
+					Value: 1,
+					Usage: "Replay this many times faster than recorded (0 for no pauses at all)",
+				},
 			},
 		},
 		},
@@ -470,7 +857,7 @@ func main() {
 		Name:   "ambilight",
 		Usage:  "Control the ambilight feature",
 		Action: withCancelCtx(dropout, handleAmbilight),
-		Flags: concat(mpdNetFlags, lightdNetFlags, ambiNetFlags, []cli.Flag{
+		Flags: concat(mpdNetFlags, lightdNetFlags, ambiNetFlags, calibrationFlags, []cli.Flag{
 			cli.StringFlag{
 				Name:   "music-dir,m",
 				Value:  "",
@@ -493,6 +880,18 @@ func main() {
 				Name:  "update-mood-db,u",
 				Usage: "Update the mood database and exit afterwards",
 			},
+			cli.StringFlag{
+				Name:   "control-token",
+				Value:  "",
+				Usage:  "Clients need this token to control ambilightd (empty: no protection)",
+				EnvVar: "AMBI_CONTROL_TOKEN",
+			},
+			cli.StringFlag{
+				Name:   "read-token",
+				Value:  "",
+				Usage:  "Clients need this token to read from ambilightd (empty: anyone may read)",
+				EnvVar: "AMBI_READ_TOKEN",
+			},
 			cli.BoolFlag{
 				Name:  "on",
 				Usage: "Enable the ambilight if it runs elsewhere",
@@ -505,11 +904,23 @@ func main() {
 				Name:  "state",
 				Usage: "Print the current state of ambilight (on/off)",
 			},
+			cli.BoolFlag{
+				Name:  "health",
+				Usage: "Print the health of the driver of ambilight (up, down or up(<restarts>))",
+			},
 			cli.BoolFlag{
 				Name:  "quit",
 				Usage: "Quit the ambilight daemon",
 			},
 		}),
+	}, {
+		Name:  "config",
+		Usage: "Work with the config file given by --config",
+		Subcommands: []cli.Command{{
+			Name:   "check",
+			Usage:  "Check the config file for errors",
+			Action: handleConfigCheck(app),
+		}},
 	},
 	}
 
@@ -1,40 +1,41 @@
 package ui
 
 import (
-	"fmt"
 	"log"
 	"time"
 
 	"golang.org/x/net/context"
 
 	"github.com/studentkittens/eulenfunk/display"
-	"github.com/studentkittens/eulenfunk/util"
 )
 
 // RunClock displays the current time in the "clock" window.
-func RunClock(lw *display.LineWriter, width int, ctx context.Context) {
+// displayd keeps the time up-to-date on its own; the widgets are only
+// declared again once a minute, in case displayd was restarted meanwhile.
+func RunClock(lw *display.LineWriter, ctx context.Context) {
 	for {
+		err := lw.Batch("clock", func() error {
+			for _, pos := range []int{1, 2} {
+				if err := lw.Attr("clock", pos, display.AttrAlign(display.AlignCenter)); err != nil {
+					return err
+				}
+			}
+
+			if err := lw.Clock("clock", 1, "15:04:05"); err != nil {
+				return err
+			}
+
+			return lw.Clock("clock", 2, "2 January 2006")
+		})
+
+		if err != nil {
+			log.Printf("Failed to send clock: %v", err)
+		}
+
 		select {
 		case <-ctx.Done():
 			return
-		default:
+		case <-time.After(time.Minute):
 		}
-
-		now := time.Now()
-		hur, min, sec := now.Clock()
-		yer, mon, day := now.Date()
-
-		tm := util.Center(fmt.Sprintf("%02d:%02d:%02d", hur, min, sec), width, ' ')
-		dt := util.Center(fmt.Sprintf("%d %s %d", day, mon.String(), yer), width, ' ')
-
-		if err := lw.Line("clock", 1, tm); err != nil {
-			log.Printf("Failed to send line 1 of clock: %v", err)
-		}
-
-		if err := lw.Line("clock", 2, dt); err != nil {
-			log.Printf("Failed to send line 1 of clock: %v", err)
-		}
-
-		time.Sleep(1 * time.Second)
 	}
 }
@@ -48,6 +48,43 @@ func (mgr *MenuManager) callAction(action Action, typ string) {
 
 	if err := action(); err != nil {
 		log.Printf("%s action failed: %v", typ, err)
+		mgr.showError(err)
+	}
+}
+
+// showError pops up a short notice about `err` in the middle of the display.
+func (mgr *MenuManager) showError(actionErr error) {
+	const window = "popup-error"
+
+	err := mgr.lw.Batch(window, func() error {
+		err := mgr.lw.Attr(
+			window, 0,
+			display.AttrAlign(display.AlignCenter),
+			display.AttrFill('━'),
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if err := mgr.lw.Line(window, 0, " ERROR "); err != nil {
+			return err
+		}
+
+		if err := mgr.lw.Line(window, 1, actionErr.Error()); err != nil {
+			return err
+		}
+
+		return mgr.lw.ScrollDelay(window, 1, 400*time.Millisecond)
+	})
+
+	if err != nil {
+		log.Printf("Failed to draw error popup: %v", err)
+		return
+	}
+
+	if err := mgr.lw.PopupRows(window, 3*time.Second, 1, 2); err != nil {
+		log.Printf("Failed to show error popup: %v", err)
 	}
 }
 
@@ -135,6 +172,14 @@ func (mgr *MenuManager) handleValueEvent(value int) {
 	mgr.display()
 }
 
+// reportInput tells displayd that the knob was used, so it lights up again
+// and leaves the screensaver.
+func (mgr *MenuManager) reportInput() {
+	if err := mgr.lw.Input(); err != nil {
+		log.Printf("Failed to report input: %v", err)
+	}
+}
+
 // NewMenuManager returns a new MenuManager that sends it's data to `lw` and switches to `initialWin`.
 func NewMenuManager(cfg *Config, lw *display.LineWriter, initialWin string) (*MenuManager, error) {
 	rty, err := util.NewRotary()
@@ -159,6 +204,7 @@ func NewMenuManager(cfg *Config, lw *display.LineWriter, initialWin string) (*Me
 
 	go func() {
 		for state := range rty.Button {
+			mgr.reportInput()
 			mgr.handleButtonEvent(state)
 		}
 	}()
@@ -171,6 +217,7 @@ func NewMenuManager(cfg *Config, lw *display.LineWriter, initialWin string) (*Me
 
 	go func() {
 		for value := range rty.Value {
+			mgr.reportInput()
 			mgr.handleValueEvent(value)
 		}
 	}()
@@ -64,14 +64,16 @@ func (mn *Menu) Scroll(move int) {
 
 // Display draws the menu onto the display
 func (mn *Menu) Display(width int) error {
-	for pos, ClickEntry := range mn.Entries {
-		line := ClickEntry.Render(width, pos == mn.Cursor)
-		if err := mn.lw.Line(mn.Name, pos, line); err != nil {
-			return err
+	return mn.lw.Batch(mn.Name, func() error {
+		for pos, ClickEntry := range mn.Entries {
+			line := ClickEntry.Render(width, pos == mn.Cursor)
+			if err := mn.lw.Line(mn.Name, pos, line); err != nil {
+				return err
+			}
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
 // Click executes the action under the cursor
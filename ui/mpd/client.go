@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,10 +25,11 @@ const (
 
 // Config defines the connection details the mpd client will use.
 type Config struct {
-	MPDHost     string
-	MPDPort     int
-	DisplayHost string
-	DisplayPort int
+	MPDHost      string
+	MPDPort      int
+	DisplayHost  string
+	DisplayPort  int
+	DisplayToken string
 }
 
 // Client is a utility mpd client tailored for the ui's purposes.
@@ -47,15 +49,32 @@ type Client struct {
 	cancel context.CancelFunc
 }
 
-func displayInfo(lw *display.LineWriter, block []string) error {
-	for idx, line := range block {
-		if err := lw.Line("mpd", idx, line); err != nil {
-			log.Printf("Failed to send line to display server: %v", err)
-			return err
+// statusTimer is a status line whose time is advanced by displayd.
+type statusTimer struct {
+	Pos    int
+	Format string
+	Span   display.TimeSpan
+}
+
+func displayInfo(lw *display.LineWriter, block []string, align string, timer *statusTimer) error {
+	return lw.Batch("mpd", func() error {
+		for idx, line := range block {
+			if err := lw.Attr("mpd", idx, display.AttrAlign(align)); err != nil {
+				return err
+			}
+
+			if err := lw.Line("mpd", idx, line); err != nil {
+				log.Printf("Failed to send line to display server: %v", err)
+				return err
+			}
 		}
-	}
 
-	return nil
+		if timer == nil {
+			return nil
+		}
+
+		return lw.Timer("mpd", timer.Pos, timer.Span, timer.Format)
+	})
 }
 
 func displayStats(lw *display.LineWriter, stats mpd.Attrs) error {
@@ -73,22 +92,24 @@ func displayStats(lw *display.LineWriter, stats mpd.Attrs) error {
 		fmt.Sprintf("%8s: %.2f days", "Playtime", dbPlaytimeDays),
 	}
 
-	for idx, line := range block {
-		if err := lw.Line("stats", idx, line); err != nil {
-			log.Printf("Failed to send line to display server: %v", err)
-			return err
+	return lw.Batch("stats", func() error {
+		for idx, line := range block {
+			if err := lw.Line("stats", idx, line); err != nil {
+				log.Printf("Failed to send line to display server: %v", err)
+				return err
+			}
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
 func formatStop(status mpd.Attrs) ([]string, error) {
 	return []string{
-		"        ⏹          ",
-		"Playback stopped.  ",
-		"Turn knob to start ",
-		"        ⏹          ",
+		"⏹",
+		"Playback stopped.",
+		"Turn knob to start",
+		"⏹",
 	}, nil
 }
 
@@ -99,21 +120,25 @@ func isRadio(currSong mpd.Attrs) bool {
 
 func displayFormatted(lw *display.LineWriter, currSong, status mpd.Attrs) error {
 	var block []string
+	var timer *statusTimer
 	var err error
 
+	align := display.AlignLeft
+
 	if status["state"] == PlaybackStop {
 		block, err = formatStop(status)
+		align = display.AlignCenter
 	} else if isRadio(currSong) {
-		block, err = formatRadio(currSong, status)
+		block, timer, err = formatRadio(currSong, status)
 	} else {
-		block, err = formatSong(currSong, status)
+		block, timer, err = formatSong(currSong, status)
 	}
 
 	if err != nil {
 		return err
 	}
 
-	if derr := displayInfo(lw, block); derr != nil {
+	if derr := displayInfo(lw, block, align, timer); derr != nil {
 		log.Printf("Failed to display status info: %v", derr)
 		return derr
 	}
@@ -121,17 +146,6 @@ func displayFormatted(lw *display.LineWriter, currSong, status mpd.Attrs) error
 	return nil
 }
 
-func formatTimeSpec(tm time.Duration) string {
-	h, m, s := int(tm.Hours()), int(tm.Minutes())%60, int(tm.Seconds())%60
-
-	f := fmt.Sprintf("%02d:%02d", m, s)
-	if h == 0 {
-		return f
-	}
-
-	return fmt.Sprintf("%02d:", h) + f
-}
-
 // StateToUnicode converts `state` into a nicer unicode glyph.
 func StateToUnicode(state string) string {
 	switch state {
@@ -146,25 +160,34 @@ func StateToUnicode(state string) string {
 	}
 }
 
-func formatStatusLine(currSong, status mpd.Attrs) string {
+// formatStatusLine returns the status line at `pos` without the time and
+// a timer that adds the time; the timer is nil if mpd did not tell it.
+func formatStatusLine(pos int, currSong, status mpd.Attrs) (string, *statusTimer) {
 	state := StateToUnicode(status["state"])
 	elapsedStr := status["elapsed"]
 
 	elapsedSec, err := strconv.ParseFloat(elapsedStr, 64)
 	if err != nil {
-		return state
+		return state, nil
+	}
+
+	span := display.TimeSpan{
+		Elapsed: time.Duration(elapsedSec*1000) * time.Millisecond,
+		Paused:  status["state"] != PlaybackPlay,
 	}
 
-	length := formatTimeSpec(time.Duration(elapsedSec*1000) * time.Millisecond)
+	length := "%e/%t"
 
 	// Append total time if available:
 	if timeStr, ok := currSong["Time"]; ok {
 		if totalSec, err := strconv.Atoi(timeStr); err == nil {
-			length += "/" + formatTimeSpec(time.Duration(totalSec)*time.Second)
+			span.Duration = time.Duration(totalSec) * time.Second
+		} else {
+			length = "%e"
 		}
 	} else {
 		// Pad the elapsed time to the right if no total time available:
-		length = "     " + length
+		length = "     %e"
 	}
 
 	bitrateStr := ""
@@ -180,21 +203,28 @@ func formatStatusLine(currSong, status mpd.Attrs) string {
 		}
 	}
 
-	return fmt.Sprintf("%s %s %s", state, bitrateStr, length)
+	line := fmt.Sprintf("%s %s ", state, bitrateStr)
+	return line, &statusTimer{
+		Pos:    pos,
+		Format: strings.Replace(line, "%", "%%", -1) + length,
+		Span:   span,
+	}
 }
 
-func formatRadio(currSong, status mpd.Attrs) ([]string, error) {
+func formatRadio(currSong, status mpd.Attrs) ([]string, *statusTimer, error) {
+	statusLine, timer := formatStatusLine(3, currSong, status)
+
 	block := []string{
 		currSong["Title"],
 		fmt.Sprintf("Radio: %s", currSong["Name"]),
 		"",
-		formatStatusLine(currSong, status),
+		statusLine,
 	}
 
-	return block, nil
+	return block, timer, nil
 }
 
-func formatSong(currSong, status mpd.Attrs) ([]string, error) {
+func formatSong(currSong, status mpd.Attrs) ([]string, *statusTimer, error) {
 	genre := currSong["Genre"]
 	if len(genre) > 0 {
 		genre = " (" + genre + ")"
@@ -202,17 +232,19 @@ func formatSong(currSong, status mpd.Attrs) ([]string, error) {
 
 	pos, err := strconv.Atoi(currSong["Pos"])
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	statusLine, timer := formatStatusLine(3, currSong, status)
+
 	block := []string{
 		fmt.Sprintf("%s", currSong["Artist"]),
 		fmt.Sprintf("%s (#%d)", currSong["Title"], pos+1),
 		fmt.Sprintf("%s%s", currSong["Album"], genre),
-		formatStatusLine(currSong, status),
+		statusLine,
 	}
 
-	return block, nil
+	return block, timer, nil
 }
 
 func (cl *Client) updatePlaylists() error {
@@ -428,8 +460,10 @@ func NewClient(cfg *Config, ctx context.Context) (*Client, error) {
 
 	MPD := NewReMPD(cfg.MPDHost, cfg.MPDPort, subCtx)
 	lw, err := display.Connect(&display.Config{
-		Host: cfg.DisplayHost,
-		Port: cfg.DisplayPort,
+		Host:         cfg.DisplayHost,
+		Port:         cfg.DisplayPort,
+		Token:        cfg.DisplayToken,
+		Acknowledged: true,
 	}, subCtx)
 
 	if err != nil {
@@ -440,11 +474,15 @@ func NewClient(cfg *Config, ctx context.Context) (*Client, error) {
 		log.Printf("Failed to send initial switch to display server: %v", err)
 	}
 
-	// Make the first 3 lines scrolling:
+	// Make the first 3 lines scrolling, with a short rest at the start:
 	for idx := 0; idx < 3; idx++ {
 		if err := lw.ScrollDelay("mpd", idx, 400*time.Millisecond); err != nil {
 			log.Printf("Failed to set scroll: %v", err)
 		}
+
+		if err := lw.Attr("mpd", idx, display.AttrPause(2*time.Second)); err != nil {
+			log.Printf("Failed to set scroll pause: %v", err)
+		}
 	}
 
 	return &Client{
@@ -507,7 +545,9 @@ func periodicUpdate(ctx context.Context, MPD *ReMPD, updateCh chan<- string) {
 	updateCh <- "stats"
 	updateCh <- "output"
 
-	lo := time.NewTicker(1 * time.Second)
+	// displayd advances the time on its own; this only catches up
+	// with changes that mpd sends no event for (like the bitrate):
+	lo := time.NewTicker(5 * time.Second)
 	hi := time.NewTicker(time.Minute)
 
 	for {
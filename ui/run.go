@@ -31,14 +31,14 @@ func boolToGlyph(b bool) string {
 
 func ambilightChangeState(cfg *Config, enable bool) error {
 	host, port := cfg.AmbilightHost, cfg.AmbilightPort
-	return ambilight.WithClient(host, port, func(client *ambilight.Client) error {
+	return ambilight.WithClient(host, port, cfg.AmbilightToken, func(client *ambilight.Client) error {
 		return client.Enable(enable)
 	})
 }
 
 func ambilightIsEnabled(cfg *Config) (enabled bool, err error) {
 	host, port := cfg.AmbilightHost, cfg.AmbilightPort
-	err = ambilight.WithClient(host, port, func(client *ambilight.Client) error {
+	err = ambilight.WithClient(host, port, cfg.AmbilightToken, func(client *ambilight.Client) error {
 		enabled, err = client.Enabled()
 		return err
 	})
@@ -212,10 +212,14 @@ func rotateAction(mgr *MenuManager, MPD *mpd.Client) error {
 	return nil
 }
 
-func schuhuAction() error {
+func schuhuAction(cfg *Config) error {
+	if cfg.HootSound == "" {
+		return nil
+	}
+
 	go func() {
 		for i := 0; i < 10; i++ {
-			runBinary("aplay", "/root/hoot.wav")
+			runBinary("aplay", cfg.HootSound)
 		}
 	}()
 	return nil
@@ -223,8 +227,10 @@ func schuhuAction() error {
 
 func shutdown(cfg *Config, lw *display.LineWriter, mode, effect string) error {
 	lightdCfg := &lightd.Config{
-		Host: cfg.LightdHost,
-		Port: cfg.LightdPort,
+		Host:     cfg.LightdHost,
+		Port:     cfg.LightdPort,
+		Token:    cfg.LightdToken,
+		Priority: lightd.PriorityAlert,
 	}
 
 	switchToStatic(lw, "shutdown")
@@ -244,11 +250,11 @@ func shutdown(cfg *Config, lw *display.LineWriter, mode, effect string) error {
 }
 
 func poweroffAction(cfg *Config, lw *display.LineWriter) error {
-	return shutdown(cfg, lw, "poweroff", "flash{200ms|{255,0,0}|5}")
+	return shutdown(cfg, lw, "poweroff", "@poweroff")
 }
 
 func rebootAction(cfg *Config, lw *display.LineWriter) error {
-	return shutdown(cfg, lw, "reboot", "flash{200ms|{255,150,0}|5}")
+	return shutdown(cfg, lw, "reboot", "@reboot")
 }
 
 /////////////////////////
@@ -331,7 +337,7 @@ func createMainMenu(mgr *MenuManager, MPD *mpd.Client) error {
 		&ClickEntry{
 			Text: "About",
 			ActionFunc: func() error {
-				if err := schuhuAction(); err != nil {
+				if err := schuhuAction(mgr.Config); err != nil {
 					log.Printf("No schuhu: %v", err)
 				}
 
@@ -384,17 +390,30 @@ type Config struct {
 	Width  int
 	Height int
 
-	DisplayHost string
-	DisplayPort int
+	DisplayHost  string
+	DisplayPort  int
+	DisplayToken string
 
 	MPDHost string
 	MPDPort int
 
-	AmbilightHost string
-	AmbilightPort int
+	AmbilightHost  string
+	AmbilightPort  int
+	AmbilightToken string
+
+	LightdHost  string
+	LightdPort  int
+	LightdToken string
 
-	LightdHost string
-	LightdPort int
+	// WeatherLatitude and WeatherLongitude are the location of the forecast.
+	WeatherLatitude  float64
+	WeatherLongitude float64
+
+	// HootSound is played by aplay in the about screen (if not empty).
+	HootSound string
+
+	// SysinfoScript prints the lines of the sysinfo window.
+	SysinfoScript string
 }
 
 /////////////////////////
@@ -427,8 +446,10 @@ func initialSwitchToMPD(mgr *MenuManager, MPD *mpd.Client) {
 func Run(cfg *Config, ctx context.Context) error {
 	log.Printf("Connecting to displayd...")
 	lw, err := display.Connect(&display.Config{
-		Host: cfg.DisplayHost,
-		Port: cfg.DisplayPort,
+		Host:         cfg.DisplayHost,
+		Port:         cfg.DisplayPort,
+		Token:        cfg.DisplayToken,
+		Acknowledged: true,
 	}, ctx)
 
 	if err != nil {
@@ -451,10 +472,11 @@ func Run(cfg *Config, ctx context.Context) error {
 	// Start auxiliary services:
 	log.Printf("Starting background services...")
 	MPD, err := mpd.NewClient(&mpd.Config{
-		MPDHost:     cfg.MPDHost,
-		MPDPort:     cfg.MPDPort,
-		DisplayHost: cfg.DisplayHost,
-		DisplayPort: cfg.DisplayPort,
+		MPDHost:      cfg.MPDHost,
+		MPDPort:      cfg.MPDPort,
+		DisplayHost:  cfg.DisplayHost,
+		DisplayPort:  cfg.DisplayPort,
+		DisplayToken: cfg.DisplayToken,
 	}, ctx)
 
 	if err != nil {
@@ -467,9 +489,9 @@ func Run(cfg *Config, ctx context.Context) error {
 	initialSwitchToMPD(mgr, MPD)
 
 	go MPD.Run()
-	go RunClock(lw, cfg.Width, ctx)
-	go RunSysinfo(lw, cfg.Width, ctx)
-	go RunWeather(lw, cfg.Width, ctx)
+	go RunClock(lw, ctx)
+	go RunSysinfo(lw, cfg, ctx)
+	go RunWeather(lw, cfg, ctx)
 
 	if err := createMainMenu(mgr, MPD); err != nil {
 		return err
@@ -482,7 +504,9 @@ func Run(cfg *Config, ctx context.Context) error {
 	mgr.AddTimedAction(600*time.Millisecond, switcher(mgr, "menu-main"))
 	mgr.AddTimedAction(2*time.Second, switcher(mgr, "menu-playlists"))
 	mgr.AddTimedAction(3*time.Second, switcher(mgr, "menu-power"))
-	mgr.AddTimedAction(8*time.Second, schuhuAction)
+	mgr.AddTimedAction(8*time.Second, func() error {
+		return schuhuAction(cfg)
+	})
 
 	mgr.ReleaseAction(func() error {
 		return releaseAction(mgr, MPD)
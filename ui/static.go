@@ -47,20 +47,22 @@ var (
 )
 
 func drawBlock(lw *display.LineWriter, window string, block []string) error {
-	for idx, line := range block {
-		if err := lw.Line(window, idx, line); err != nil {
-			return err
-		}
-
-		// Needs scrolling for proper display:
-		if utf8.RuneCountInString(line) > 20 {
-			if err := lw.ScrollDelay(window, idx, 500*time.Millisecond); err != nil {
+	return lw.Batch(window, func() error {
+		for idx, line := range block {
+			if err := lw.Line(window, idx, line); err != nil {
 				return err
 			}
+
+			// Needs scrolling for proper display:
+			if utf8.RuneCountInString(line) > 20 {
+				if err := lw.ScrollDelay(window, idx, 500*time.Millisecond); err != nil {
+					return err
+				}
+			}
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
 func pageThrough(lw *display.LineWriter, window string, blocks [][]string) {
@@ -12,8 +12,8 @@ import (
 )
 
 // RunSysinfo displays system information in the "sysinfo" window.
-// The data is obtained from the "radio-sysinfo.sh" script.
-func RunSysinfo(lw *display.LineWriter, width int, ctx context.Context) {
+// The data is obtained from cfg.SysinfoScript (usually "radio-sysinfo.sh").
+func RunSysinfo(lw *display.LineWriter, cfg *Config, ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -21,9 +21,9 @@ func RunSysinfo(lw *display.LineWriter, width int, ctx context.Context) {
 		default:
 		}
 
-		sysinfo, err := exec.Command("radio-sysinfo.sh").Output()
+		sysinfo, err := exec.Command(cfg.SysinfoScript).Output()
 		if err != nil {
-			log.Printf("Failed to execute radio-sysinfo.sh: %v", err)
+			log.Printf("Failed to execute %s: %v", cfg.SysinfoScript, err)
 			time.Sleep(30 * time.Second)
 			continue
 		}
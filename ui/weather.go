@@ -11,7 +11,6 @@ import (
 
 	owm "github.com/briandowns/openweathermap"
 	"github.com/studentkittens/eulenfunk/display"
-	"github.com/studentkittens/eulenfunk/util"
 )
 
 func celsius(c float64) string {
@@ -37,18 +36,17 @@ func degToDirection(deg int) string {
 	}
 }
 
-func weatherForecast() (*owm.ForecastWeatherData, error) {
+func weatherForecast(cfg *Config) (*owm.ForecastWeatherData, error) {
 	w, err := owm.NewForecast("C", "DE")
 	if err != nil {
 		log.Printf("Failed to instance new forecast: %v", err)
 		return nil, err
 	}
 
-	// That's Augsburg:
 	err = w.DailyByCoordinates(
 		&owm.Coordinates{
-			Latitude:  48.3830555,
-			Longitude: 10.8830555,
+			Latitude:  cfg.WeatherLatitude,
+			Longitude: cfg.WeatherLongitude,
 		},
 		3, // 3 days of forecast
 	)
@@ -77,7 +75,7 @@ func toScreen(w *owm.ForecastWeatherData, p *owm.ForecastWeatherList, dayOff, wi
 
 	status := "No weather today."
 	if len(p.Weather) > 0 {
-		status = util.Center(p.Weather[0].Description, width, ' ')
+		status = p.Weather[0].Description
 	}
 
 	humidity := p.Humidity
@@ -118,8 +116,10 @@ func errorScreen(width int) [][]string {
 	}}
 }
 
-func downloadData(width int) [][]string {
-	w, err := weatherForecast()
+func downloadData(cfg *Config) [][]string {
+	width := cfg.Width
+
+	w, err := weatherForecast(cfg)
 	if err != nil {
 		log.Printf("Failed to retrieve forecast: %v", err)
 		return errorScreen(width)
@@ -134,12 +134,25 @@ func downloadData(width int) [][]string {
 }
 
 func displayWeather(lw *display.LineWriter, screen []string) {
-	for idx, line := range screen {
-		if err := lw.Line("weather", idx, line); err != nil {
-			log.Printf("Failed to display weather widget: %v", err)
+	err := lw.Batch("weather", func() error {
+		// The status line is centered:
+		if err := lw.Attr("weather", 1, display.AttrAlign(display.AlignCenter)); err != nil {
+			return err
 		}
 
-		log.Printf("weather: %02d: %s", idx, line)
+		for idx, line := range screen {
+			if err := lw.Line("weather", idx, line); err != nil {
+				return err
+			}
+
+			log.Printf("weather: %02d: %s", idx, line)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Printf("Failed to display weather widget: %v", err)
 	}
 }
 
@@ -152,11 +165,11 @@ func init() {
 }
 
 // RunWeather displays a weather forecast in the "weather" window.
-func RunWeather(lw *display.LineWriter, width int, ctx context.Context) {
+func RunWeather(lw *display.LineWriter, cfg *Config, ctx context.Context) {
 	switchTicker := time.NewTicker(10 * time.Second)
 	updateTicker := time.NewTicker(30 * time.Minute)
 
-	screens := downloadData(width)
+	screens := downloadData(cfg)
 	screenIdx := 0
 
 	if len(screens) > 0 {
@@ -167,7 +180,7 @@ func RunWeather(lw *display.LineWriter, width int, ctx context.Context) {
 		select {
 		// Update the data:
 		case <-updateTicker.C:
-			screens = downloadData(width)
+			screens = downloadData(cfg)
 		// Toggle through:
 		case <-switchTicker.C:
 			if screenIdx < len(screens) {
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"crypto/subtle"
+	"fmt"
+)
+
+// Permission is what a connection to one of our daemons may do.
+type Permission int
+
+const (
+	// PermNone allows nothing but authenticating and closing the connection.
+	PermNone Permission = iota
+
+	// PermRead allows commands that only ask for information.
+	PermRead
+
+	// PermControl allows all commands.
+	PermControl
+)
+
+func (perm Permission) String() string {
+	switch perm {
+	case PermRead:
+		return "read"
+	case PermControl:
+		return "control"
+	default:
+		return "none"
+	}
+}
+
+// Tokens are the shared secrets of a daemon.
+//
+// If Control is empty, every connection may do everything (like before
+// tokens existed). Otherwise, connections need to authenticate with Control
+// to change anything. If Read is empty too, reading is allowed to everyone;
+// else it needs Read (or Control).
+type Tokens struct {
+	Control string
+	Read    string
+}
+
+func equalToken(given, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// Grant returns the permission of a connection that presented `token`.
+// An empty `token` gives the permission of unauthenticated connections.
+func (tk Tokens) Grant(token string) Permission {
+	switch {
+	case tk.Control == "":
+		return PermControl
+	case equalToken(token, tk.Control):
+		return PermControl
+	case equalToken(token, tk.Read):
+		return PermRead
+	case tk.Read == "":
+		return PermRead
+	default:
+		return PermNone
+	}
+}
+
+// Access tracks the permission of a single connection.
+type Access struct {
+	Tokens Tokens
+	Perm   Permission
+}
+
+// NewAccess returns the access of a new, unauthenticated connection.
+func NewAccess(tokens Tokens) *Access {
+	return &Access{Tokens: tokens, Perm: tokens.Grant("")}
+}
+
+// Auth authenticates the connection with `token`.
+// A wrong token leaves the permission as it was.
+func (ac *Access) Auth(token string) error {
+	// Nothing is protected; clients may send a token anyways:
+	if ac.Tokens.Control == "" {
+		return nil
+	}
+
+	if !equalToken(token, ac.Tokens.Control) && !equalToken(token, ac.Tokens.Read) {
+		return fmt.Errorf("Wrong token")
+	}
+
+	ac.Perm = ac.Tokens.Grant(token)
+	return nil
+}
+
+// Check returns an error if `cmd` needs more than the connection may do.
+func (ac *Access) Check(cmd string, needed Permission) error {
+	if ac.Perm < needed {
+		return fmt.Errorf("Permission denied for `%s` (needs %s, have %s)", cmd, needed, ac.Perm)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// UnixPrefix marks a host as the path of a unix domain socket,
+// e.g. "unix:///run/eulenfunk/displayd.sock". The port is ignored then.
+const UnixPrefix = "unix://"
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// Address returns the network and the address for `host` and `port`,
+// as taken by net.Dial and net.Listen.
+func Address(host string, port int) (string, string) {
+	if strings.HasPrefix(host, UnixPrefix) {
+		return "unix", strings.TrimPrefix(host, UnixPrefix)
+	}
+
+	return "tcp", fmt.Sprintf("%s:%d", host, port)
+}
+
+// Dial connects to `host` and `port`; see Address.
+func Dial(host string, port int) (net.Conn, error) {
+	network, addr := Address(host, port)
+	return net.Dial(network, addr)
+}
+
+var activation struct {
+	sync.Once
+	sync.Mutex
+	lsn   net.Listener
+	err   error
+	taken bool
+}
+
+// activationListener returns the socket passed by systemd socket activation
+// (see sd_listen_fds(3)), or nil if we were not started that way.
+// Only the first socket is used and only the first caller gets it; further
+// listeners of a daemon (like the display mirror) bind their own address.
+func activationListener() (net.Listener, error) {
+	activation.Do(func() {
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return
+		}
+
+		nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || nfds < 1 {
+			return
+		}
+
+		// Driver programs started by us should not try to use them:
+		for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			if err := os.Unsetenv(name); err != nil {
+				log.Printf("Failed to unset $%s: %v", name, err)
+			}
+		}
+
+		if nfds > 1 {
+			log.Printf("Got %d sockets from systemd; only using the first", nfds)
+		}
+
+		syscall.CloseOnExec(listenFdsStart)
+
+		fd := os.NewFile(listenFdsStart, "LISTEN_FD_3")
+		defer Closer(fd)
+
+		activation.lsn, activation.err = net.FileListener(fd)
+	})
+
+	activation.Lock()
+	defer activation.Unlock()
+
+	if activation.taken {
+		return nil, nil
+	}
+
+	activation.taken = true
+	return activation.lsn, activation.err
+}
+
+// Listen returns a listener for `host` and `port`; see Address.
+// When started by systemd socket activation, the passed socket is used
+// instead for the first call. A stale unix socket of a crashed daemon is removed first.
+func Listen(host string, port int) (net.Listener, error) {
+	lsn, err := activationListener()
+	if lsn != nil || err != nil {
+		return lsn, err
+	}
+
+	network, addr := Address(host, port)
+	if network == "unix" {
+		if conn, err := net.Dial(network, addr); err == nil {
+			Closer(conn)
+			return nil, fmt.Errorf("Socket `%s` is already in use", addr)
+		}
+
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return net.Listen(network, addr)
+}
+
+// Accept waits for a connection on `lsn`, but only for `timeout`, so the
+// caller can check if it should stop in between. It returns a nil
+// connection and nil error if the timeout passed.
+func Accept(lsn net.Listener, timeout time.Duration) (net.Conn, error) {
+	if dl, ok := lsn.(interface {
+		SetDeadline(time.Time) error
+	}); ok {
+		if err := dl.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := lsn.Accept()
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return nil, nil
+	}
+
+	return conn, err
+}
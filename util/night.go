@@ -0,0 +1,89 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NightSchedule is a daily time range like "22:00-07:00".
+type NightSchedule struct {
+	// Start and End are the minutes since midnight.
+	Start, End int
+}
+
+func parseClock(spec string) (int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(spec, "%d:%d", &hour, &minute); err != nil {
+		return 0, fmt.Errorf("Bad time of day `%s` (hh:mm expected)", spec)
+	}
+
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("Bad time of day `%s`", spec)
+	}
+
+	return hour*60 + minute, nil
+}
+
+// ParseNightSchedule parses a range like "22:00-07:00".
+// An empty `spec` gives a nil schedule.
+func ParseNightSchedule(spec string) (*NightSchedule, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	split := strings.Split(spec, "-")
+	if len(split) != 2 {
+		return nil, fmt.Errorf("Bad night schedule `%s` (hh:mm-hh:mm expected)", spec)
+	}
+
+	start, err := parseClock(split[0])
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := parseClock(split[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if start == end {
+		return nil, fmt.Errorf("Night schedule `%s` is empty", spec)
+	}
+
+	return &NightSchedule{Start: start, End: end}, nil
+}
+
+// at returns the time at `minutes` since midnight on the day of `now`.
+func at(now time.Time, minutes int) time.Time {
+	year, month, day := now.Date()
+	return time.Date(year, month, day, minutes/60, minutes%60, 0, 0, now.Location())
+}
+
+// Contains returns true if it is night at `now`.
+func (ns *NightSchedule) Contains(now time.Time) bool {
+	minutes := now.Hour()*60 + now.Minute()
+	if ns.Start < ns.End {
+		return ns.Start <= minutes && minutes < ns.End
+	}
+
+	// The night goes over midnight:
+	return minutes >= ns.Start || minutes < ns.End
+}
+
+// Next returns when the night starts or ends next after `now`.
+func (ns *NightSchedule) Next(now time.Time) time.Time {
+	next := time.Time{}
+	for _, minutes := range []int{ns.Start, ns.End} {
+		change := at(now, minutes)
+		if !change.After(now) {
+			change = at(now.AddDate(0, 0, 1), minutes)
+		}
+
+		if next.IsZero() || change.Before(next) {
+			next = change
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,316 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMinBackoff is the delay before the first restart of a driver.
+	DefaultMinBackoff = 500 * time.Millisecond
+
+	// DefaultMaxBackoff is the longest delay between two restarts.
+	DefaultMaxBackoff = 30 * time.Second
+
+	// DefaultKillTimeout is the time a driver gets to quit on Close.
+	DefaultKillTimeout = 5 * time.Second
+
+	// stableAfter is the time a driver needs to run before a crash
+	// is not counted as part of a crash loop anymore.
+	stableAfter = time.Minute
+)
+
+// ErrSupervisorClosed is returned when writing to a closed Supervisor.
+var ErrSupervisorClosed = errors.New("Driver supervisor was closed")
+
+// DriverHealth describes the state of a supervised driver.
+type DriverHealth struct {
+	// Running is true while the driver process is alive.
+	Running bool
+
+	// PID is the process id of the current (or last) driver process.
+	PID int
+
+	// Restarts is the number of times the driver was restarted.
+	Restarts int
+
+	// Since is the time when the current (or last) process was started.
+	Since time.Time
+
+	// LastExit tells why the driver exited last; empty if it never did.
+	LastExit string
+}
+
+// String returns "up" or "down" with the number of restarts, if any.
+func (dh DriverHealth) String() string {
+	state := "down"
+	if dh.Running {
+		state = "up"
+	}
+
+	if dh.Restarts > 0 {
+		return fmt.Sprintf("%s(%d)", state, dh.Restarts)
+	}
+
+	return state
+}
+
+// lineLogger logs everything written to it line by line.
+type lineLogger struct {
+	prefix string
+	buf    []byte
+}
+
+func (ll *lineLogger) Write(p []byte) (int, error) {
+	ll.buf = append(ll.buf, p...)
+
+	for {
+		idx := bytes.IndexByte(ll.buf, '\n')
+		if idx < 0 {
+			break
+		}
+
+		log.Printf("%s: %s", ll.prefix, ll.buf[:idx])
+		ll.buf = ll.buf[idx+1:]
+	}
+
+	return len(p), nil
+}
+
+// Supervisor runs a driver program and writes to its stdin.
+// When the driver exits, it is restarted with an increasing delay.
+// Its stdout and stderr end up in our log.
+//
+// Writes while the driver is down are dropped. After a restart, the last
+// write is repeated if RepeatLastWrite is set and Restarted is called,
+// so the owner can bring the driver back to the last known state.
+type Supervisor struct {
+	sync.Mutex
+
+	// Name is the driver binary, Args are passed to it.
+	Name string
+	Args []string
+
+	// MinBackoff and MaxBackoff limit the delay between restarts.
+	MinBackoff, MaxBackoff time.Duration
+
+	// KillTimeout is the time the driver gets to quit after Close
+	// closed its stdin; it is killed afterwards.
+	KillTimeout time.Duration
+
+	// RepeatLastWrite makes the supervisor write the data of the last
+	// Write again after a restart. Useful for drivers that take one
+	// complete state per line, like the LED driver.
+	RepeatLastWrite bool
+
+	// Restarted is called (if not nil) after every restart.
+	// It may write to the supervisor.
+	Restarted func()
+
+	stdin  io.WriteCloser
+	cmd    *exec.Cmd
+	health DriverHealth
+	last   []byte
+	closed bool
+	done   chan struct{}
+
+	// exited is closed when watch returns; no driver runs anymore then.
+	exited chan struct{}
+}
+
+// NewSupervisor returns a supervisor for the driver `name` with `args`.
+// It needs to be started with Start.
+func NewSupervisor(name string, args ...string) *Supervisor {
+	return &Supervisor{
+		Name:        name,
+		Args:        args,
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		KillTimeout: DefaultKillTimeout,
+		done:        make(chan struct{}),
+		exited:      make(chan struct{}),
+	}
+}
+
+func (sv *Supervisor) start() (*exec.Cmd, error) {
+	cmd := exec.Command(sv.Name, sv.Args...)
+	cmd.Stdout = &lineLogger{prefix: sv.Name}
+	cmd.Stderr = &lineLogger{prefix: sv.Name}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	sv.Lock()
+	defer sv.Unlock()
+
+	sv.cmd, sv.stdin = cmd, stdin
+	sv.health.Running = true
+	sv.health.PID = cmd.Process.Pid
+	sv.health.Since = time.Now()
+	return cmd, nil
+}
+
+// Start starts the driver for the first time. An error is only returned
+// if that fails; later crashes are handled by restarting the driver.
+func (sv *Supervisor) Start() error {
+	cmd, err := sv.start()
+	if err != nil {
+		return fmt.Errorf("Failed to start driver `%s`: %v", sv.Name, err)
+	}
+
+	go sv.watch(cmd)
+	return nil
+}
+
+// watch waits for `cmd` to exit and restarts it, until Close is called.
+func (sv *Supervisor) watch(cmd *exec.Cmd) {
+	defer close(sv.exited)
+
+	backoff := sv.MinBackoff
+
+	for {
+		err := cmd.Wait()
+
+		sv.Lock()
+		closed, since := sv.closed, sv.health.Since
+		sv.health.Running = false
+		sv.health.LastExit = "exited normally"
+		if err != nil {
+			sv.health.LastExit = err.Error()
+		}
+		sv.Unlock()
+
+		if closed {
+			return
+		}
+
+		if time.Since(since) > stableAfter {
+			backoff = sv.MinBackoff
+		}
+
+		log.Printf("Driver `%s` stopped (%v); restarting in %s", sv.Name, err, backoff)
+
+		for {
+			select {
+			case <-sv.done:
+				return
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > sv.MaxBackoff {
+				backoff = sv.MaxBackoff
+			}
+
+			if cmd, err = sv.start(); err == nil {
+				break
+			}
+
+			log.Printf("Failed to restart driver `%s`: %v; retry in %s", sv.Name, err, backoff)
+		}
+
+		sv.Lock()
+		sv.health.Restarts++
+		last := sv.last
+		sv.Unlock()
+
+		log.Printf("Driver `%s` is running again (pid %d)", sv.Name, cmd.Process.Pid)
+
+		if sv.RepeatLastWrite && last != nil {
+			if _, err := sv.Write(last); err != nil {
+				log.Printf("Failed to repeat last write: %v", err)
+			}
+		}
+
+		if sv.Restarted != nil {
+			sv.Restarted()
+		}
+	}
+}
+
+// Write sends `p` to the stdin of the driver.
+// It only fails after Close was called.
+func (sv *Supervisor) Write(p []byte) (int, error) {
+	sv.Lock()
+	defer sv.Unlock()
+
+	if sv.closed {
+		return 0, ErrSupervisorClosed
+	}
+
+	if sv.RepeatLastWrite {
+		sv.last = append(sv.last[:0], p...)
+	}
+
+	if !sv.health.Running {
+		return len(p), nil
+	}
+
+	if _, err := sv.stdin.Write(p); err != nil {
+		// The driver probably just died; watch() will notice.
+		log.Printf("Failed to write to driver `%s`: %v", sv.Name, err)
+		sv.health.Running = false
+	}
+
+	return len(p), nil
+}
+
+// Health returns the current state of the driver.
+func (sv *Supervisor) Health() DriverHealth {
+	sv.Lock()
+	defer sv.Unlock()
+
+	return sv.health
+}
+
+// Close stops the driver and does not restart it anymore.
+// A driver that does not quit within KillTimeout is killed.
+func (sv *Supervisor) Close() error {
+	sv.Lock()
+	if sv.closed || sv.cmd == nil {
+		sv.closed = true
+		sv.Unlock()
+		return nil
+	}
+
+	sv.closed = true
+	close(sv.done)
+
+	// Closing stdin makes well-behaving drivers quit by themselves:
+	var err error
+	if sv.health.Running {
+		err = sv.stdin.Close()
+	}
+	sv.Unlock()
+
+	timer := time.NewTimer(sv.KillTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-sv.exited:
+		return err
+	case <-timer.C:
+	}
+
+	sv.Lock()
+	cmd := sv.cmd
+	sv.Unlock()
+
+	log.Printf("Driver `%s` did not quit within %s; killing it", sv.Name, sv.KillTimeout)
+	if killErr := cmd.Process.Kill(); killErr != nil {
+		log.Printf("Failed to kill driver `%s`: %v", sv.Name, killErr)
+	}
+
+	return err
+}
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls `cond` until it is true or `timeout` passed.
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return cond()
+}
+
+func TestSupervisorRestartBackoff(t *testing.T) {
+	sv := NewSupervisor("sh", "-c", "exit 1")
+	sv.MinBackoff = 20 * time.Millisecond
+	sv.MaxBackoff = 80 * time.Millisecond
+
+	mu := sync.Mutex{}
+	restarts := []time.Time{}
+	sv.Restarted = func() {
+		mu.Lock()
+		restarts = append(restarts, time.Now())
+		mu.Unlock()
+	}
+
+	if err := sv.Start(); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+
+	defer Closer(sv)
+
+	enough := waitFor(5*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(restarts) >= 5
+	})
+
+	if !enough {
+		t.Fatalf("Driver was not restarted 5 times")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The delay doubles after each crash, up to MaxBackoff:
+	wantMin := []time.Duration{40, 80, 80, 80}
+	for idx, min := range wantMin {
+		gap := restarts[idx+1].Sub(restarts[idx])
+		if gap < min*time.Millisecond {
+			t.Errorf("Restart %d came after %s (want at least %dms)", idx+2, gap, min)
+		}
+	}
+
+	health := sv.Health()
+	if health.Restarts < 5 {
+		t.Errorf("Health reports %d restarts (want at least 5)", health.Restarts)
+	}
+
+	if !strings.Contains(health.LastExit, "exit status 1") {
+		t.Errorf("Unexpected last exit `%s`", health.LastExit)
+	}
+}
+
+func TestSupervisorCloseKills(t *testing.T) {
+	// sleep does not quit when its stdin is closed:
+	sv := NewSupervisor("sh", "-c", "exec sleep 10")
+	sv.KillTimeout = 50 * time.Millisecond
+
+	if err := sv.Start(); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+
+	start := time.Now()
+	if err := sv.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Close took %s", took)
+	}
+
+	stopped := waitFor(2*time.Second, func() bool {
+		return !sv.Health().Running
+	})
+
+	if !stopped {
+		t.Fatalf("Driver still runs after Close")
+	}
+
+	if _, err := sv.Write([]byte("hello\n")); err != ErrSupervisorClosed {
+		t.Errorf("Write after Close gave %v", err)
+	}
+}